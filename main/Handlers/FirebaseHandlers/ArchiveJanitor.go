@@ -0,0 +1,363 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArchiveJanitor erases deleted accounts for good once their grace period
+// ran out: the archived ratings with their replies and reactions, the replies,
+// reactions, poll votes and invitations of the user elsewhere, the archived
+// user, the archived profile pictures and the Firebase Auth record. Every
+// purged user gets an entry in PurgeAudit, so the erasure of an account can be
+// proven later on. A dry run keeps one entry per user, which every run updates.
+type ArchiveJanitor struct {
+	AuthHandler *auth.Client
+	FireStore   *firestore.Client
+	Pictures    *ProfilePictures
+	Interval    time.Duration
+	DryRun      bool
+	mutex       sync.Mutex
+}
+
+// PurgeAudit records what was purged of a user. Comments counts the replies
+// and reactions below the user's ratings as well as those the user left below
+// the ratings of others, Polls the polls the user was removed from.
+type PurgeAudit struct {
+	UserId     string    `firestore:"userId" json:"userId"`
+	DryRun     bool      `firestore:"dryRun" json:"dryRun"`
+	ExpiredAt  time.Time `firestore:"expiredAt" json:"expiredAt"`
+	PurgedAt   time.Time `firestore:"purgedAt" json:"purgedAt"`
+	User       bool      `firestore:"user" json:"user"`
+	Ratings    int       `firestore:"ratings" json:"ratings"`
+	Comments   int       `firestore:"comments" json:"comments"`
+	Polls      int       `firestore:"polls" json:"polls"`
+	Pictures   int       `firestore:"pictures" json:"pictures"`
+	AuthRecord bool      `firestore:"authRecord" json:"authRecord"`
+	Errors     []string  `firestore:"errors,omitempty" json:"errors,omitempty"`
+}
+
+// expiredArchive collects what of a user has expired.
+type expiredArchive struct {
+	expiredAt time.Time
+	user      bool
+	ratings   []*firestore.DocumentSnapshot
+	pictures  bool
+}
+
+func (e *expiredArchive) expire(expiresAt time.Time) {
+	if expiresAt.After(e.expiredAt) {
+		e.expiredAt = expiresAt
+	}
+}
+
+func (j *ArchiveJanitor) Run() {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		_, err := j.purgeExpired(j.DryRun)
+		if err != nil {
+			log.Printf("Failed to purge expired archives: %v", err)
+		}
+	}
+}
+
+// findExpired groups everything whose grace period ended before now by user.
+func (j *ArchiveJanitor) findExpired(now time.Time) (map[string]*expiredArchive, error) {
+	expired := map[string]*expiredArchive{}
+	archive := func(userId string) *expiredArchive {
+		if expired[userId] == nil {
+			expired[userId] = &expiredArchive{}
+		}
+		return expired[userId]
+	}
+
+	users, err := j.FireStore.Collection("ArchivedUsers").Where("expiresAt", "<=", now).Documents(context.Background()).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived users: %w", err)
+	}
+	for _, doc := range users {
+		var user User
+		err = doc.DataTo(&user)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		entry := archive(doc.Ref.ID)
+		entry.user = true
+		entry.expire(user.ExpiresAt)
+	}
+
+	ratings, err := j.FireStore.Collection("ArchivedRatings").Where("expiresAt", "<=", now).Documents(context.Background()).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived ratings: %w", err)
+	}
+	for _, doc := range ratings {
+		var rating Rating
+		err = doc.DataTo(&rating)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		entry := archive(rating.UserId)
+		entry.ratings = append(entry.ratings, doc)
+		entry.expire(rating.ExpiresAt)
+	}
+
+	pictures, err := j.Pictures.expiredArchives(now)
+	if err != nil {
+		return nil, err
+	}
+	for userId, expiresAt := range pictures {
+		entry := archive(userId)
+		entry.pictures = true
+		entry.expire(expiresAt)
+	}
+	return expired, nil
+}
+
+// deleteCollection deletes every document of the collection and returns how many there were.
+func deleteCollection(collection *firestore.CollectionRef, dryRun bool) (int, error) {
+	docs, err := collection.Documents(context.Background()).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(docs), nil
+	}
+	for index, doc := range docs {
+		_, err = doc.Ref.Delete(context.Background())
+		if err != nil {
+			return index, err
+		}
+	}
+	return len(docs), nil
+}
+
+// purgeRatings deletes the archived ratings together with the replies and
+// reactions, which stay behind below the original rating when it is archived.
+// Those are left alone if the rating itself has been restored.
+func (j *ArchiveJanitor) purgeRatings(entry *expiredArchive, audit *PurgeAudit, dryRun bool) {
+	for _, doc := range entry.ratings {
+		ratingRef := j.FireStore.Collection("Ratings").Doc(doc.Ref.ID)
+		_, err := ratingRef.Get(context.Background())
+		if status.Code(err) == codes.NotFound {
+			for _, subcollection := range []string{"Replies", "Reactions"} {
+				deleted, err := deleteCollection(ratingRef.Collection(subcollection), dryRun)
+				audit.Comments += deleted
+				if err != nil {
+					audit.Errors = append(audit.Errors, fmt.Sprintf("failed to delete %s of %s: %v", subcollection, doc.Ref.ID, err))
+				}
+			}
+		}
+		if !dryRun {
+			_, err := doc.Ref.Delete(context.Background())
+			if err != nil {
+				audit.Errors = append(audit.Errors, fmt.Sprintf("failed to delete rating %s: %v", doc.Ref.ID, err))
+				continue
+			}
+		}
+		audit.Ratings++
+	}
+}
+
+// userRestored reports whether the account was restored onto the same uid.
+func (j *ArchiveJanitor) userRestored(userId string) (bool, error) {
+	_, err := j.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err == nil {
+		return true, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return false, fmt.Errorf("failed to check for a restored user: %w", err)
+	}
+	return false, nil
+}
+
+// purgeContributions deletes the replies and reactions the user left below the
+// ratings of others and removes the user's votes and invitations from polls.
+// The replies and reactions below the user's own ratings are left to
+// purgeRatings.
+func (j *ArchiveJanitor) purgeContributions(userId string, entry *expiredArchive, audit *PurgeAudit, dryRun bool) {
+	ownRatings := map[string]bool{}
+	for _, doc := range entry.ratings {
+		ownRatings[doc.Ref.ID] = true
+	}
+	for _, group := range []string{"Replies", "Reactions"} {
+		docs, err := j.FireStore.CollectionGroup(group).Where("userId", "==", userId).Documents(context.Background()).GetAll()
+		if err != nil {
+			audit.Errors = append(audit.Errors, fmt.Sprintf("failed to get %s: %v", group, err))
+			continue
+		}
+		for _, doc := range docs {
+			if ownRatings[doc.Ref.Parent.Parent.ID] {
+				continue
+			}
+			if !dryRun {
+				_, err = doc.Ref.Delete(context.Background())
+				if err != nil {
+					audit.Errors = append(audit.Errors, fmt.Sprintf("failed to delete %s %s: %v", group, doc.Ref.Path, err))
+					continue
+				}
+			}
+			audit.Comments++
+		}
+	}
+
+	polls := map[string]*firestore.DocumentRef{}
+	for _, query := range []firestore.Query{
+		j.FireStore.Collection("Polls").Where("invitees", "array-contains", userId),
+		j.FireStore.Collection("Polls").Where("creatorId", "==", userId),
+	} {
+		docs, err := query.Documents(context.Background()).GetAll()
+		if err != nil {
+			audit.Errors = append(audit.Errors, fmt.Sprintf("failed to get polls: %v", err))
+			continue
+		}
+		for _, doc := range docs {
+			polls[doc.Ref.ID] = doc.Ref
+		}
+	}
+	for pollId, ref := range polls {
+		if !dryRun {
+			_, err := ref.Update(context.Background(), []firestore.Update{
+				{FieldPath: firestore.FieldPath{"votes", userId}, Value: firestore.Delete},
+				{Path: "invitees", Value: firestore.ArrayRemove(userId)},
+			})
+			if err != nil {
+				audit.Errors = append(audit.Errors, fmt.Sprintf("failed to remove user from poll %s: %v", pollId, err))
+				continue
+			}
+		}
+		audit.Polls++
+	}
+}
+
+// purgeUser deletes the archived user and its auth record. The auth record is
+// kept if the account was restored onto the same uid in the meantime.
+func (j *ArchiveJanitor) purgeUser(userId string, audit *PurgeAudit, dryRun bool) {
+	if !dryRun {
+		_, err := j.FireStore.Collection("ArchivedUsers").Doc(userId).Delete(context.Background())
+		if err != nil {
+			audit.Errors = append(audit.Errors, fmt.Sprintf("failed to delete user: %v", err))
+			return
+		}
+		_, err = j.FireStore.Collection("DeletionJobs").Doc(userId).Delete(context.Background())
+		if err != nil {
+			log.Printf("Failed to delete deletion job of %s: %v", userId, err)
+		}
+	}
+	audit.User = true
+
+	restored, err := j.userRestored(userId)
+	if err != nil {
+		audit.Errors = append(audit.Errors, err.Error())
+		return
+	}
+	if restored {
+		return
+	}
+	_, err = j.AuthHandler.GetUser(context.Background(), userId)
+	if auth.IsUserNotFound(err) {
+		return
+	}
+	if err == nil && !dryRun {
+		err = j.AuthHandler.DeleteUser(context.Background(), userId)
+	}
+	if err != nil {
+		audit.Errors = append(audit.Errors, fmt.Sprintf("failed to delete auth record: %v", err))
+		return
+	}
+	audit.AuthRecord = true
+}
+
+func (j *ArchiveJanitor) purge(userId string, entry *expiredArchive, dryRun bool) PurgeAudit {
+	audit := PurgeAudit{
+		UserId:    userId,
+		DryRun:    dryRun,
+		ExpiredAt: entry.expiredAt,
+		PurgedAt:  time.Now(),
+	}
+	j.purgeRatings(entry, &audit, dryRun)
+	if entry.pictures || entry.user {
+		deleted, err := j.Pictures.purge(userId, dryRun)
+		audit.Pictures = deleted
+		if err != nil {
+			audit.Errors = append(audit.Errors, err.Error())
+		}
+	}
+	if entry.user {
+		// A user restored onto the same uid still owns their contributions
+		restored, err := j.userRestored(userId)
+		if err != nil {
+			audit.Errors = append(audit.Errors, err.Error())
+		} else if !restored {
+			j.purgeContributions(userId, entry, &audit, dryRun)
+		}
+		j.purgeUser(userId, &audit, dryRun)
+	}
+
+	var err error
+	if dryRun {
+		// A dry run repeats on every tick, so only its latest audit is kept per user
+		_, err = j.FireStore.Collection("PurgeAudit").Doc("dryRun-"+userId).Set(context.Background(), audit)
+	} else {
+		_, _, err = j.FireStore.Collection("PurgeAudit").Add(context.Background(), audit)
+	}
+	if err != nil {
+		log.Printf("Failed to write purge audit of %s: %v", userId, err)
+	}
+	log.Printf("Purged %s (dry run: %v): user %v, %d ratings, %d comments, %d polls, %d pictures, auth record %v, %d errors",
+		userId, dryRun, audit.User, audit.Ratings, audit.Comments, audit.Polls, audit.Pictures, audit.AuthRecord, len(audit.Errors))
+	return audit
+}
+
+// purgeExpired purges every user with expired archives and returns the audit entries.
+func (j *ArchiveJanitor) purgeExpired(dryRun bool) ([]PurgeAudit, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	expired, err := j.findExpired(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	userIds := make([]string, 0, len(expired))
+	for userId := range expired {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	audits := make([]PurgeAudit, 0, len(userIds))
+	for _, userId := range userIds {
+		audits = append(audits, j.purge(userId, expired[userId], dryRun))
+	}
+	return audits, nil
+}
+
+// ServeHTTP lets admins run the purge right away. Pass dryRun=true to only
+// see what would be purged.
+func (j *ArchiveJanitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorized, _ := Handlers.AdminWrapper(w, r, j.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	dryRun := j.DryRun || r.URL.Query().Get("dryRun") == "true"
+	audits, err := j.purgeExpired(dryRun)
+	if err != nil {
+		log.Printf("Failed to purge expired archives: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	Handlers.WriteJSON(w, audits)
+}
@@ -0,0 +1,316 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"google.golang.org/api/iterator"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AvailabilityHandler lets users follow titles and notifies them once a
+// followed title is streamable on one of their services, or once its rent or
+// buy price drops below the threshold of their price alert.
+type AvailabilityHandler struct {
+	AuthHandler  *auth.Client
+	FireStore    *firestore.Client
+	MongoHandler *MovieHandlers.MongoHandler
+	FcmHandler   *FcmHandler
+	Interval     time.Duration
+}
+
+func movieLink(movieId string) string {
+	return fmt.Sprintf("/inspect/%s?from=/", movieId)
+}
+
+func (a *AvailabilityHandler) Run() {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		a.checkFollowedTitles()
+	}
+}
+
+func (a *AvailabilityHandler) checkFollowedTitles() {
+	a.forEachUser(a.FireStore.Collection("Users").Where("followedTitles", "!=", []string{}), func(userRef *firestore.DocumentRef, user User) {
+		if len(user.FollowedTitles) > 0 && len(user.StreamingServices) > 0 {
+			a.checkUser(userRef, user)
+		}
+	})
+	a.forEachUser(a.FireStore.Collection("Users").Where("priceAlerts", "!=", map[string]float64{}), func(userRef *firestore.DocumentRef, user User) {
+		if len(user.PriceAlerts) > 0 {
+			a.checkPriceAlerts(userRef, user)
+		}
+	})
+}
+
+// WatchedTitles returns the titles users follow or have price alerts for,
+// which the cache refresher keeps fresh first.
+func (a *AvailabilityHandler) WatchedTitles() []string {
+	seen := map[string]bool{}
+	var movieIds []string
+	add := func(movieId string) {
+		if !seen[movieId] {
+			seen[movieId] = true
+			movieIds = append(movieIds, movieId)
+		}
+	}
+	a.forEachUser(a.FireStore.Collection("Users").Where("followedTitles", "!=", []string{}), func(_ *firestore.DocumentRef, user User) {
+		for _, movieId := range user.FollowedTitles {
+			add(movieId)
+		}
+	})
+	a.forEachUser(a.FireStore.Collection("Users").Where("priceAlerts", "!=", map[string]float64{}), func(_ *firestore.DocumentRef, user User) {
+		for movieId := range user.PriceAlerts {
+			add(movieId)
+		}
+	})
+	return movieIds
+}
+
+func (a *AvailabilityHandler) forEachUser(query firestore.Query, check func(*firestore.DocumentRef, User)) {
+	iter := query.Documents(context.Background())
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			return
+		}
+		var user User
+		err = doc.DataTo(&user)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		check(doc.Ref, user)
+	}
+}
+
+// streamableServices returns the services of the user the movie is streamable on.
+func streamableServices(movie MovieHandlers.MovieResponse, country string, services []string) []string {
+	streamable := make([]string, 0)
+	for _, service := range services {
+		if movie.StreamableOn(country, service) {
+			streamable = append(streamable, service)
+		}
+	}
+	return streamable
+}
+
+// newServices returns the services of current that are not in notified.
+func newServices(current, notified []string) []string {
+	var added []string
+	for _, service := range current {
+		found := false
+		for _, notifiedService := range notified {
+			if service == notifiedService {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, service)
+		}
+	}
+	return added
+}
+
+// checkUser notifies the user once a followed title became streamable on
+// another of their services. The services the user was notified about are
+// stored per title, so a title is only announced again after it changed.
+func (a *AvailabilityHandler) checkUser(userRef *firestore.DocumentRef, user User) {
+	movies, err := a.MongoHandler.FetchManyFromCache(user.FollowedTitles)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		return
+	}
+	country := userCountry(user)
+	for _, movieId := range user.FollowedTitles {
+		movie, ok := movies[movieId]
+		if !ok {
+			continue
+		}
+		notified := user.NotifiedTitles[movieId]
+		current := streamableServices(movie, country, user.StreamingServices)
+		added := newServices(current, notified)
+		if len(added) == 0 && len(current) == len(notified) {
+			continue
+		}
+
+		var value interface{} = current
+		if len(current) == 0 {
+			value = firestore.Delete
+		}
+		_, err := userRef.Update(context.Background(), []firestore.Update{{FieldPath: firestore.FieldPath{"notifiedTitles", movieId}, Value: value}})
+		if err != nil {
+			log.Printf("Failed to update user: %v", err)
+			continue
+		}
+		if len(added) > 0 {
+			log.Printf("Notifying %s that %s is streamable on %s", userRef.ID, movieId, added[0])
+			a.FcmHandler.SendNotification(user.FcmToken, fmt.Sprintf("%s is now streaming on %s", movie.Title, added[0]), movieLink(movieId))
+		}
+	}
+}
+
+// checkPriceAlerts notifies the user about every title whose price on one of
+// their services dropped below the threshold. The alerted price is recorded,
+// so the user is only notified again once the price drops further, or after
+// it rose above the threshold and dropped below it again.
+func (a *AvailabilityHandler) checkPriceAlerts(userRef *firestore.DocumentRef, user User) {
+	movieIds := make([]string, 0, len(user.PriceAlerts))
+	for movieId := range user.PriceAlerts {
+		movieIds = append(movieIds, movieId)
+	}
+	movies, err := a.MongoHandler.FetchManyFromCache(movieIds)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		return
+	}
+	country := userCountry(user)
+	for movieId, threshold := range user.PriceAlerts {
+		movie, ok := movies[movieId]
+		if !ok {
+			continue
+		}
+		alerted, wasAlerted := user.AlertedPrices[movieId]
+		amount, service, formatted, ok := movie.LowestPrice(country, user.StreamingServices)
+		if !ok || amount >= threshold {
+			if wasAlerted {
+				_, err := userRef.Update(context.Background(), []firestore.Update{{FieldPath: firestore.FieldPath{"alertedPrices", movieId}, Value: firestore.Delete}})
+				if err != nil {
+					log.Printf("Failed to update user: %v", err)
+				}
+			}
+			continue
+		}
+		if wasAlerted && amount >= alerted {
+			continue
+		}
+		_, err := userRef.Update(context.Background(), []firestore.Update{{FieldPath: firestore.FieldPath{"alertedPrices", movieId}, Value: amount}})
+		if err != nil {
+			log.Printf("Failed to update user: %v", err)
+			continue
+		}
+		log.Printf("Notifying %s that %s costs %v on %s", userRef.ID, movieId, amount, service)
+		a.FcmHandler.SendNotification(user.FcmToken, fmt.Sprintf("%s is now available for %s on %s", movie.Title, formatted, service), movieLink(movieId))
+	}
+}
+
+func (a *AvailabilityHandler) updateFollowedTitles(userId string, value interface{}) (int, string) {
+	_, err := a.FireStore.Collection("Users").Doc(userId).Update(context.Background(), []firestore.Update{{Path: "followedTitles", Value: value}})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		return 500, "Internal Server Error"
+	}
+	return 200, "Ok"
+}
+
+func (a *AvailabilityHandler) FollowWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, a.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+
+	code, message := a.updateFollowedTitles(token.UID, firestore.ArrayUnion(movieId))
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (a *AvailabilityHandler) UnfollowWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, a.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+
+	code, message := a.updateFollowedTitles(token.UID, firestore.ArrayRemove(movieId))
+	if code == 200 {
+		_, err := a.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{{FieldPath: firestore.FieldPath{"notifiedTitles", movieId}, Value: firestore.Delete}})
+		if err != nil {
+			log.Printf("Failed to update user: %v", err)
+		}
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+// SetPriceAlertWrapper asks to be notified once renting or buying the movie
+// costs less than the threshold.
+func (a *AvailabilityHandler) SetPriceAlertWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, a.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+	threshold, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64)
+	if err != nil || threshold <= 0 {
+		http.Error(w, "Invalid threshold", http.StatusBadRequest)
+		return
+	}
+
+	// A new threshold starts over, even if an alert was sent for the old one
+	_, err = a.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{
+		{FieldPath: firestore.FieldPath{"priceAlerts", movieId}, Value: threshold},
+		{FieldPath: firestore.FieldPath{"alertedPrices", movieId}, Value: firestore.Delete},
+	})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("Ok"))
+}
+
+func (a *AvailabilityHandler) RemovePriceAlertWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, a.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+
+	_, err := a.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{
+		{FieldPath: firestore.FieldPath{"priceAlerts", movieId}, Value: firestore.Delete},
+		{FieldPath: firestore.FieldPath{"alertedPrices", movieId}, Value: firestore.Delete},
+	})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("Ok"))
+}
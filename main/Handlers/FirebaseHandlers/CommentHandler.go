@@ -0,0 +1,399 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxReplyLength = 500
+	maxEmojiLength = 8
+)
+
+// CommentHandler manages the replies and reactions friends leave on a rating.
+// Both are stored in subcollections of the rating, reactions keyed by the
+// reacting user so everyone has at most one.
+type CommentHandler struct {
+	AuthHandler *auth.Client
+	FireStore   *firestore.Client
+	FcmHandler  *FcmHandler
+}
+
+type replyResponse struct {
+	Id        string     `json:"id"`
+	UserId    string     `json:"userId"`
+	Text      string     `json:"text"`
+	Timestamp time.Time  `json:"timestamp"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+}
+
+type reactionResponse struct {
+	UserId    string    `json:"userId"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type commentsResponse struct {
+	Replies   []replyResponse    `json:"replies"`
+	Reactions []reactionResponse `json:"reactions"`
+}
+
+type ratingAccess struct {
+	rating    Rating
+	ratingRef *firestore.DocumentRef
+	user      User
+	code      int
+	message   string
+}
+
+func ratingLink(rating Rating) string {
+	return fmt.Sprintf("/profile/inspect/%s?from=/", rating.UserId)
+}
+
+func validReply(text string) bool {
+	length := utf8.RuneCountInString(strings.TrimSpace(text))
+	return length > 0 && length <= maxReplyLength
+}
+
+// validEmoji only accepts short strings without letters, digits or spaces, which
+// still allows modifiers and zero width joiners of combined emoji.
+func validEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// getRating loads the rating and makes sure the user is either its author or a friend of the author.
+func (c *CommentHandler) getRating(userId, ratingId string) ratingAccess {
+	ratingDoc, err := c.FireStore.Collection("Ratings").Doc(ratingId).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get rating: %v", err)
+		return ratingAccess{code: 404, message: "rating doesn't exist"}
+	}
+	var rating Rating
+	err = ratingDoc.DataTo(&rating)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return ratingAccess{code: 500, message: "Internal Server Error"}
+	}
+	userDoc, err := c.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return ratingAccess{code: 404, message: "user doesn't exist"}
+	}
+	var user User
+	err = userDoc.DataTo(&user)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return ratingAccess{code: 500, message: "Internal Server Error"}
+	}
+	if rating.UserId != userId && !Handlers.ArrayContains(user.Friends, rating.UserId) {
+		return ratingAccess{code: 403, message: "Not friends with user"}
+	}
+	return ratingAccess{rating, ratingDoc.Ref, user, 200, "Ok"}
+}
+
+// notifyAuthor tells the author of the rating about activity from someone else.
+func (c *CommentHandler) notifyAuthor(access ratingAccess, userId, content string) {
+	if access.rating.UserId == userId {
+		return
+	}
+	author := c.FcmHandler.getUserInfo(access.rating.UserId)
+	c.FcmHandler.SendNotification(author.FcmToken, content, ratingLink(access.rating))
+}
+
+func (c *CommentHandler) listComments(userId, ratingId string) (commentsResponse, int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return commentsResponse{}, access.code, access.message
+	}
+
+	response := commentsResponse{Replies: []replyResponse{}, Reactions: []reactionResponse{}}
+	iter := access.ratingRef.Collection("Replies").OrderBy("timestamp", firestore.Asc).Documents(context.Background())
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			return commentsResponse{}, 500, "Internal Server Error"
+		}
+		var reply Reply
+		err = doc.DataTo(&reply)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		entry := replyResponse{Id: doc.Ref.ID, UserId: reply.UserId, Text: reply.Text, Timestamp: reply.Timestamp}
+		if !reply.EditedAt.IsZero() {
+			editedAt := reply.EditedAt
+			entry.EditedAt = &editedAt
+		}
+		response.Replies = append(response.Replies, entry)
+	}
+
+	iter = access.ratingRef.Collection("Reactions").OrderBy("timestamp", firestore.Asc).Documents(context.Background())
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			return commentsResponse{}, 500, "Internal Server Error"
+		}
+		var reaction Reaction
+		err = doc.DataTo(&reaction)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		response.Reactions = append(response.Reactions, reactionResponse(reaction))
+	}
+	return response, 200, "Ok"
+}
+
+func (c *CommentHandler) addReply(userId, ratingId, text string) (string, int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return "", access.code, access.message
+	}
+	ref, _, err := access.ratingRef.Collection("Replies").Add(context.Background(), Reply{
+		UserId:    userId,
+		Text:      strings.TrimSpace(text),
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to add reply: %v", err)
+		return "", 500, "Internal Server Error"
+	}
+	go c.notifyAuthor(access, userId, fmt.Sprintf("%s replied to your rating", access.user.Name))
+	return ref.ID, 200, "Ok"
+}
+
+// getOwnReply loads a reply and checks that it was written by the user.
+func (c *CommentHandler) getOwnReply(access ratingAccess, userId, replyId string) (*firestore.DocumentRef, int, string) {
+	ref := access.ratingRef.Collection("Replies").Doc(replyId)
+	doc, err := ref.Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get reply: %v", err)
+		return nil, 404, "reply doesn't exist"
+	}
+	var reply Reply
+	err = doc.DataTo(&reply)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return nil, 500, "Internal Server Error"
+	}
+	if reply.UserId != userId {
+		return nil, 403, "Not your reply"
+	}
+	return ref, 200, "Ok"
+}
+
+func (c *CommentHandler) editReply(userId, ratingId, replyId, text string) (int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return access.code, access.message
+	}
+	ref, code, message := c.getOwnReply(access, userId, replyId)
+	if code != 200 {
+		return code, message
+	}
+	_, err := ref.Update(context.Background(), []firestore.Update{
+		{Path: "text", Value: strings.TrimSpace(text)},
+		{Path: "editedAt", Value: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to edit reply: %v", err)
+		return 500, "Internal Server Error"
+	}
+	return 200, "Ok"
+}
+
+// deleteReply lets users remove their own replies, and the author of the rating any reply on it.
+func (c *CommentHandler) deleteReply(userId, ratingId, replyId string) (int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return access.code, access.message
+	}
+	ref := access.ratingRef.Collection("Replies").Doc(replyId)
+	if access.rating.UserId != userId {
+		var code int
+		var message string
+		ref, code, message = c.getOwnReply(access, userId, replyId)
+		if code != 200 {
+			return code, message
+		}
+	}
+	_, err := ref.Delete(context.Background())
+	if err != nil {
+		log.Printf("Failed to delete reply: %v", err)
+		return 500, "Internal Server Error"
+	}
+	return 200, "Ok"
+}
+
+// react sets the user's reaction, replacing any earlier one. The author is
+// only notified about the first reaction of a user.
+func (c *CommentHandler) react(userId, ratingId, emoji string) (int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return access.code, access.message
+	}
+	ref := access.ratingRef.Collection("Reactions").Doc(userId)
+	_, err := ref.Get(context.Background())
+	isNew := status.Code(err) == codes.NotFound
+	if err != nil && !isNew {
+		log.Printf("Failed to get reaction: %v", err)
+		return 500, "Internal Server Error"
+	}
+	_, err = ref.Set(context.Background(), Reaction{UserId: userId, Emoji: emoji, Timestamp: time.Now()})
+	if err != nil {
+		log.Printf("Failed to set reaction: %v", err)
+		return 500, "Internal Server Error"
+	}
+	if isNew {
+		go c.notifyAuthor(access, userId, fmt.Sprintf("%s reacted %s to your rating", access.user.Name, emoji))
+	}
+	return 200, "Ok"
+}
+
+func (c *CommentHandler) removeReaction(userId, ratingId string) (int, string) {
+	access := c.getRating(userId, ratingId)
+	if access.code != 200 {
+		return access.code, access.message
+	}
+	_, err := access.ratingRef.Collection("Reactions").Doc(userId).Delete(context.Background())
+	if err != nil {
+		log.Printf("Failed to remove reaction: %v", err)
+		return 500, "Internal Server Error"
+	}
+	return 200, "Ok"
+}
+
+func (c *CommentHandler) ListWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	if ratingId == "" {
+		http.Error(w, "Missing ratingId", http.StatusBadRequest)
+		return
+	}
+
+	comments, code, message := c.listComments(token.UID, ratingId)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, comments)
+}
+
+func (c *CommentHandler) ReplyWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	text := r.URL.Query().Get("text")
+	if ratingId == "" || !validReply(text) {
+		http.Error(w, "Missing ratingId or invalid text", http.StatusBadRequest)
+		return
+	}
+
+	replyId, code, message := c.addReply(token.UID, ratingId, text)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, map[string]string{"id": replyId})
+}
+
+func (c *CommentHandler) EditReplyWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	replyId := r.URL.Query().Get("replyId")
+	text := r.URL.Query().Get("text")
+	if ratingId == "" || replyId == "" || !validReply(text) {
+		http.Error(w, "Missing ratingId, replyId or invalid text", http.StatusBadRequest)
+		return
+	}
+
+	code, message := c.editReply(token.UID, ratingId, replyId, text)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (c *CommentHandler) DeleteReplyWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	replyId := r.URL.Query().Get("replyId")
+	if ratingId == "" || replyId == "" {
+		http.Error(w, "Missing ratingId or replyId", http.StatusBadRequest)
+		return
+	}
+
+	code, message := c.deleteReply(token.UID, ratingId, replyId)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (c *CommentHandler) ReactWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	emoji := r.URL.Query().Get("emoji")
+	if ratingId == "" || !validEmoji(emoji) {
+		http.Error(w, "Missing ratingId or invalid emoji", http.StatusBadRequest)
+		return
+	}
+
+	code, message := c.react(token.UID, ratingId, emoji)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (c *CommentHandler) RemoveReactionWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, c.AuthHandler)
+	if !authorized {
+		return
+	}
+	ratingId := r.URL.Query().Get("ratingId")
+	if ratingId == "" {
+		http.Error(w, "Missing ratingId", http.StatusBadRequest)
+		return
+	}
+
+	code, message := c.removeReaction(token.UID, ratingId)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
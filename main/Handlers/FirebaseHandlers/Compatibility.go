@@ -0,0 +1,146 @@
+package FirebaseHandlers
+
+import (
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// Weights of the parts of a compatibility score, renormalized over the parts
+// that are defined for a pair of users.
+const (
+	correlationWeight = 0.5
+	genreAgreeWeight  = 0.3
+	overlapWeight     = 0.2
+	// compatibilityHighlights is the number of agreements and disagreements returned.
+	compatibilityHighlights = 5
+)
+
+type ratingComparison struct {
+	MovieId      string  `json:"movieId"`
+	Title        string  `json:"title,omitempty"`
+	UserRating   float64 `json:"userRating"`
+	FriendRating float64 `json:"friendRating"`
+}
+
+type compatibility struct {
+	Score            float64            `json:"score"`
+	SharedMovies     int                `json:"sharedMovies"`
+	Overlap          float64            `json:"overlap"`
+	Correlation      *float64           `json:"correlation,omitempty"`
+	GenreAgreement   *float64           `json:"genreAgreement,omitempty"`
+	TopAgreements    []ratingComparison `json:"topAgreements"`
+	TopDisagreements []ratingComparison `json:"topDisagreements"`
+}
+
+func compareTastes(user, friend tasteProfile, movies map[string]MovieHandlers.MovieResponse) compatibility {
+	movieIds, userRatings, friendRatings := sharedRatings(user, friend)
+	result := compatibility{SharedMovies: len(movieIds)}
+
+	union := len(user.ratings) + len(friend.ratings) - len(movieIds)
+	if union > 0 {
+		result.Overlap = float64(len(movieIds)) / float64(union)
+	}
+	score, weights := overlapWeight*result.Overlap, overlapWeight
+	if correlation, ok := pearson(userRatings, friendRatings); ok {
+		result.Correlation = &correlation
+		score += correlationWeight * (correlation + 1) / 2
+		weights += correlationWeight
+	}
+	if agreement, ok := cosine(user.genres, friend.genres); ok {
+		result.GenreAgreement = &agreement
+		score += genreAgreeWeight * (agreement + 1) / 2
+		weights += genreAgreeWeight
+	}
+	result.Score = math.Round(100 * score / weights)
+
+	comparisons := make([]ratingComparison, 0, len(movieIds))
+	for index, movieId := range movieIds {
+		comparisons = append(comparisons, ratingComparison{
+			MovieId:      movieId,
+			Title:        movies[movieId].Title,
+			UserRating:   userRatings[index],
+			FriendRating: friendRatings[index],
+		})
+	}
+	difference := func(c ratingComparison) float64 {
+		return math.Abs(c.UserRating - c.FriendRating)
+	}
+	// Agreements on movies both liked come first
+	sort.Slice(comparisons, func(i, j int) bool {
+		if difference(comparisons[i]) == difference(comparisons[j]) {
+			return comparisons[i].UserRating+comparisons[i].FriendRating > comparisons[j].UserRating+comparisons[j].FriendRating
+		}
+		return difference(comparisons[i]) < difference(comparisons[j])
+	})
+	// With few shared movies the agreements take the better half and the
+	// disagreements the rest, so no movie ends up in both lists
+	count := compatibilityHighlights
+	if half := (len(comparisons) + 1) / 2; count > half {
+		count = half
+	}
+	result.TopAgreements = append([]ratingComparison{}, comparisons[:count]...)
+	result.TopDisagreements = make([]ratingComparison, 0, compatibilityHighlights)
+	for index := len(comparisons) - 1; index >= count && len(result.TopDisagreements) < compatibilityHighlights; index-- {
+		if difference(comparisons[index]) == 0 {
+			break
+		}
+		result.TopDisagreements = append(result.TopDisagreements, comparisons[index])
+	}
+	return result
+}
+
+func (t *TasteHandler) compatibility(userId, friendId string) (compatibility, int, string) {
+	parsed := t.FriendHandler.getAndParse(userId, friendId)
+	if parsed.code != 200 {
+		return compatibility{}, parsed.code, parsed.message
+	}
+	if !Handlers.ArrayContains(parsed.user.Friends, friendId) {
+		return compatibility{}, 403, "Not friends with user"
+	}
+
+	ratings, err := t.fetchRatings([]string{userId, friendId})
+	if err != nil {
+		log.Printf("Failed to get ratings: %v", err)
+		return compatibility{}, 500, "Internal Server Error"
+	}
+	movieIds := make([]string, 0)
+	for _, rating := range append(ratings[userId], ratings[friendId]...) {
+		if !Handlers.ArrayContains(movieIds, rating.MovieId) {
+			movieIds = append(movieIds, rating.MovieId)
+		}
+	}
+	movies, err := t.MongoHandler.FetchManyFromCache(movieIds)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		return compatibility{}, 500, "Internal Server Error"
+	}
+
+	user := buildTasteProfile(ratings[userId], movies)
+	friend := buildTasteProfile(ratings[friendId], movies)
+	return compareTastes(user, friend, movies), 200, "Ok"
+}
+
+// CompatibilityWrapper compares the caller's taste with the one of a friend.
+func (t *TasteHandler) CompatibilityWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, t.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	friendId := r.URL.Query().Get("friendId")
+	if friendId == "" {
+		http.Error(w, "Missing friendId", http.StatusBadRequest)
+		return
+	}
+
+	result, code, message := t.compatibility(token.UID, friendId)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, result)
+}
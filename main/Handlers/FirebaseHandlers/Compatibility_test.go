@@ -0,0 +1,66 @@
+package FirebaseHandlers
+
+import (
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"testing"
+)
+
+func TestCompareTastesHighlightsDoNotOverlap(t *testing.T) {
+	user := tasteProfile{ratings: map[string]float64{"a": 9, "b": 8, "c": 2}}
+	friend := tasteProfile{ratings: map[string]float64{"a": 9, "b": 6, "c": 9}}
+	movies := map[string]MovieHandlers.MovieResponse{"a": {Title: "A"}}
+
+	result := compareTastes(user, friend, movies)
+	if result.SharedMovies != 3 {
+		t.Errorf("SharedMovies = %d, want 3", result.SharedMovies)
+	}
+	if len(result.TopAgreements) != 2 || result.TopAgreements[0].MovieId != "a" || result.TopAgreements[1].MovieId != "b" {
+		t.Errorf("TopAgreements = %+v, want a and b", result.TopAgreements)
+	}
+	if result.TopAgreements[0].Title != "A" {
+		t.Errorf("TopAgreements[0].Title = %q, want A", result.TopAgreements[0].Title)
+	}
+	if len(result.TopDisagreements) != 1 || result.TopDisagreements[0].MovieId != "c" {
+		t.Errorf("TopDisagreements = %+v, want only c", result.TopDisagreements)
+	}
+}
+
+func TestCompareTastesLimitsHighlights(t *testing.T) {
+	user := tasteProfile{ratings: map[string]float64{}}
+	friend := tasteProfile{ratings: map[string]float64{}}
+	for index, movieId := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		user.ratings[movieId] = 10
+		friend.ratings[movieId] = float64(10 - index%10)
+	}
+
+	result := compareTastes(user, friend, nil)
+	if len(result.TopAgreements) != compatibilityHighlights || len(result.TopDisagreements) != compatibilityHighlights {
+		t.Fatalf("got %d agreements and %d disagreements, want %d each",
+			len(result.TopAgreements), len(result.TopDisagreements), compatibilityHighlights)
+	}
+	seen := map[string]bool{}
+	for _, comparison := range result.TopAgreements {
+		seen[comparison.MovieId] = true
+	}
+	for _, comparison := range result.TopDisagreements {
+		if seen[comparison.MovieId] {
+			t.Errorf("%s is both an agreement and a disagreement", comparison.MovieId)
+		}
+	}
+	if result.TopDisagreements[0].FriendRating != 1 {
+		t.Errorf("TopDisagreements[0] = %+v, want the largest difference first", result.TopDisagreements[0])
+	}
+}
+
+func TestCompareTastesWithoutDisagreements(t *testing.T) {
+	user := tasteProfile{ratings: map[string]float64{"a": 7, "b": 5}}
+	friend := tasteProfile{ratings: map[string]float64{"a": 7, "b": 5, "c": 3}}
+
+	result := compareTastes(user, friend, nil)
+	if len(result.TopDisagreements) != 0 {
+		t.Errorf("TopDisagreements = %+v, want none for equal ratings", result.TopDisagreements)
+	}
+	if result.Overlap != 2.0/3.0 {
+		t.Errorf("Overlap = %v, want 2/3", result.Overlap)
+	}
+}
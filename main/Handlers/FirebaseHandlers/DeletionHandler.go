@@ -4,119 +4,368 @@ import (
 	"cloud.google.com/go/firestore"
 	"context"
 	"firebase.google.com/go/v4/auth"
+	"fmt"
 	"github.com/ItzBubschki/mr-backend/main/Handlers"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"net/http"
 	"time"
 )
 
+const (
+	stepRatings  = "ratings"
+	stepUser     = "user"
+	stepFriends  = "friends"
+	stepPictures = "pictures"
+	stepMail     = "restoreMail"
+
+	archiveGracePeriod   = 14 * 24 * time.Hour
+	deletionBatchSize    = 200
+	maxDeletionAttempts  = 5
+	deletionJobsInterval = 10 * time.Minute
+)
+
+// deletionSteps are run in this order, every step can safely be run again
+// after it failed or the server stopped in the middle of it.
+var deletionSteps = []string{stepRatings, stepUser, stepFriends, stepPictures, stepMail}
+
+// DeletionHandler archives accounts. Every deletion is persisted as a job in
+// DeletionJobs, keyed by the user id, so that Run can pick up jobs that did
+// not finish. Once archived, the user is mailed a token to restore the account.
 type DeletionHandler struct {
 	AuthHandler *auth.Client
 	FireStore   *firestore.Client
+	Pictures    *ProfilePictures
+	Tokens      *RestoreTokens
+	Mailer      Mailer
+	Interval    time.Duration
+	jobs        jobLocks
+}
+
+type DeletionJob struct {
+	UserId    string            `firestore:"userId"`
+	Status    string            `firestore:"status"`
+	Steps     map[string]string `firestore:"steps"`
+	Error     string            `firestore:"error,omitempty"`
+	Attempts  int               `firestore:"attempts"`
+	ExpiresAt time.Time         `firestore:"expiresAt"`
+	CreatedAt time.Time         `firestore:"createdAt"`
+	UpdatedAt time.Time         `firestore:"updatedAt"`
+}
+
+// deletionJobResponse leaves out the error of a failed job, which is only
+// logged, and tells the app that the job is retried instead.
+type deletionJobResponse struct {
+	Status    string            `json:"status"`
+	Steps     map[string]string `json:"steps"`
+	Error     string            `json:"error,omitempty"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
 }
 
-func (d *DeletionHandler) moveUserRatings(userId string) {
-	query := d.FireStore.Collection("Ratings").Where("userId", "==", userId)
+func (job DeletionJob) toResponse() deletionJobResponse {
+	response := deletionJobResponse{
+		Status:    job.Status,
+		Steps:     job.Steps,
+		ExpiresAt: job.ExpiresAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	if job.Status == jobFailed {
+		response.Error = "Deletion failed, it is retried automatically"
+	}
+	return response
+}
+
+// moveUserRatings moves the ratings in batches, each batch in its own
+// transaction, so that users with many ratings stay below the write limit.
+func (d *DeletionHandler) moveUserRatings(userId string, expiresAt time.Time) error {
+	query := d.FireStore.Collection("Ratings").Where("userId", "==", userId).Limit(deletionBatchSize)
 	archivedRatings := d.FireStore.Collection("ArchivedRatings")
-	err := d.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
-		iter := tx.Documents(query)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
+	for {
+		moved := 0
+		err := d.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+			moved = 0
+			docs, err := tx.Documents(query).GetAll()
 			if err != nil {
-				log.Printf("Failed to iterate: %v", err)
 				return err
 			}
-			var rating Rating
-			err = doc.DataTo(&rating)
-			if err != nil {
-				log.Printf("Failed to convert data: %v", err)
-				return err
+			for _, doc := range docs {
+				var rating Rating
+				err = doc.DataTo(&rating)
+				if err != nil {
+					return err
+				}
+				rating.ExpiresAt = expiresAt
+				err = tx.Set(archivedRatings.Doc(doc.Ref.ID), rating)
+				if err != nil {
+					return err
+				}
+				err = tx.Delete(doc.Ref)
+				if err != nil {
+					return err
+				}
+				moved++
 			}
-			rating.ExpiresAt = time.Now().Add(time.Hour * 24 * 14)
-			newRating := archivedRatings.Doc(doc.Ref.ID)
-			err = tx.Create(newRating, rating)
-			if err != nil {
-				log.Printf("Failed to create: %v", err)
-				return err
-			}
-			err = tx.Delete(doc.Ref)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move ratings: %w", err)
+		}
+		if moved < deletionBatchSize {
+			return nil
+		}
+	}
+}
+
+// moveUserData archives the user document. A missing user document means an
+// earlier attempt already moved it.
+func (d *DeletionHandler) moveUserData(userId string, expiresAt time.Time) error {
+	userDoc := d.FireStore.Collection("Users").Doc(userId)
+	archivedDoc := d.FireStore.Collection("ArchivedUsers").Doc(userId)
+	err := d.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		userData, err := tx.Get(userDoc)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var user User
+		err = userData.DataTo(&user)
+		if err != nil {
+			return err
+		}
+		user.ExpiresAt = expiresAt
+		err = tx.Set(archivedDoc, user)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(userDoc)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive user: %w", err)
+	}
+	return nil
+}
+
+func (d *DeletionHandler) removeUserFromFriends(userId string) error {
+	for _, field := range []string{"friends", "friendRequests", "outgoingRequests"} {
+		query := d.FireStore.Collection("Users").Where(field, "array-contains", userId)
+		err := d.removeUserFromFieldInQuery(query, field, userId)
+		if err != nil {
+			return fmt.Errorf("failed to remove user from %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+func (d *DeletionHandler) removeUserFromFieldInQuery(query firestore.Query, field string, userId string) error {
+	return d.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			log.Printf("Removing user %v from %s of %v", userId, field, doc.Ref.ID)
+			err = tx.Update(doc.Ref, []firestore.Update{
+				{
+					Path:  field,
+					Value: firestore.ArrayRemove(userId),
+				},
+			})
 			if err != nil {
-				log.Printf("Failed to delete: %v", err)
 				return err
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		return
-	}
 }
 
-func (d *DeletionHandler) moveUserData(userId string) {
-	userDoc := d.FireStore.Collection("Users").Doc(userId)
-	userData, err := userDoc.Get(context.Background())
+// mailRestoreToken sends the restore token to the email of the archived user.
+func (d *DeletionHandler) mailRestoreToken(userId string) error {
+	if d.Mailer == nil || d.Tokens == nil {
+		return nil
+	}
+	userDoc, err := d.FireStore.Collection("ArchivedUsers").Doc(userId).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		// The user never had a profile, so there is nothing to restore
+		return nil
+	}
 	if err != nil {
-		log.Printf("Failed to get user: %v", err)
-		return
+		return fmt.Errorf("failed to get archived user: %w", err)
 	}
 	var user User
-	err = userData.DataTo(&user)
+	err = userDoc.DataTo(&user)
 	if err != nil {
-		log.Printf("Failed to convert data: %v", err)
-		return
+		return fmt.Errorf("failed to convert archived user: %w", err)
 	}
-	user.ExpiresAt = time.Now().Add(time.Hour * 24 * 14)
-	_, err = d.FireStore.Collection("ArchivedUsers").Doc(userId).Set(context.Background(), user)
+	err = sendRestoreMail(d.Mailer, d.Tokens, userId, user)
 	if err != nil {
-		log.Printf("Failed to archive user: %v", err)
-		return
+		return fmt.Errorf("failed to mail restore token: %w", err)
 	}
-	_, err = userDoc.Delete(context.Background())
+	return nil
 }
 
-func (d *DeletionHandler) removeUserFromFriends(userId string) {
-	query := d.FireStore.Collection("Users").Where("friends", "array-contains", userId)
-	d.removeUserFromFieldInQuery(query, "friends", userId)
-
-	query = d.FireStore.Collection("Users").Where("friendRequests", "array-contains", userId)
-	d.removeUserFromFieldInQuery(query, "friendRequests", userId)
+func (d *DeletionHandler) runStep(job DeletionJob, step string) error {
+	switch step {
+	case stepRatings:
+		return d.moveUserRatings(job.UserId, job.ExpiresAt)
+	case stepUser:
+		return d.moveUserData(job.UserId, job.ExpiresAt)
+	case stepFriends:
+		return d.removeUserFromFriends(job.UserId)
+	case stepPictures:
+		return d.Pictures.Archive(job.UserId, job.ExpiresAt)
+	case stepMail:
+		return d.mailRestoreToken(job.UserId)
+	}
+	return fmt.Errorf("unknown step %s", step)
 }
 
-func (d *DeletionHandler) removeUserFromFieldInQuery(query firestore.Query, field string, userId string) {
+// startJob creates the job of the user, or returns the existing one if the
+// deletion was already requested and is not done yet.
+func (d *DeletionHandler) startJob(userId string) (DeletionJob, error) {
+	ref := d.FireStore.Collection("DeletionJobs").Doc(userId)
+	var job DeletionJob
 	err := d.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
-		iter := tx.Documents(query)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				log.Printf("Failed to iterate: %v", err)
-				return err
-			}
-			var user User
-			err = doc.DataTo(&user)
-			if err != nil {
-				log.Printf("Failed to convert data: %v", err)
+		doc, err := tx.Get(ref)
+		if err == nil {
+			err = doc.DataTo(&job)
+			if err != nil || job.Status != jobDone {
 				return err
 			}
-			log.Printf("Removing user %v from %s of %v", userId, field, doc.Ref.ID)
-			err = tx.Update(doc.Ref, []firestore.Update{
-				{
-					Path:  field,
-					Value: firestore.ArrayRemove(userId),
-				},
-			})
+		} else if status.Code(err) != codes.NotFound {
+			return err
 		}
-		return nil
+		job = DeletionJob{
+			UserId:    userId,
+			Status:    jobPending,
+			Steps:     map[string]string{},
+			ExpiresAt: time.Now().Add(archiveGracePeriod),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		for _, step := range deletionSteps {
+			job.Steps[step] = jobPending
+		}
+		return tx.Set(ref, job)
 	})
+	return job, err
+}
+
+func (d *DeletionHandler) getJob(userId string) (DeletionJob, error) {
+	doc, err := d.FireStore.Collection("DeletionJobs").Doc(userId).Get(context.Background())
 	if err != nil {
-		log.Printf("Failed to remove user from friends: %v", err)
+		return DeletionJob{}, err
+	}
+	var job DeletionJob
+	err = doc.DataTo(&job)
+	return job, err
+}
+
+func (d *DeletionHandler) saveJob(job *DeletionJob) {
+	job.UpdatedAt = time.Now()
+	_, err := d.FireStore.Collection("DeletionJobs").Doc(job.UserId).Set(context.Background(), *job)
+	if err != nil {
+		log.Printf("Failed to save deletion job of %s: %v", job.UserId, err)
+	}
+}
+
+// runJob runs all steps that are not done yet and stops at the first failing one.
+func (d *DeletionHandler) runJob(job DeletionJob) DeletionJob {
+	if job.Status == jobDone || !d.jobs.claim(job.UserId) {
+		return job
+	}
+	defer d.jobs.release(job.UserId)
+
+	job.Status = jobRunning
+	job.Error = ""
+	job.Attempts++
+	d.saveJob(&job)
+	for _, step := range deletionSteps {
+		if job.Steps[step] == jobDone {
+			continue
+		}
+		err := d.runStep(job, step)
+		if err != nil {
+			log.Printf("Deletion of %s failed at step %s: %v", job.UserId, step, err)
+			job.Steps[step] = jobFailed
+			job.Status = jobFailed
+			job.Error = err.Error()
+			d.saveJob(&job)
+			return job
+		}
+		job.Steps[step] = jobDone
+		d.saveJob(&job)
+	}
+	job.Status = jobDone
+	d.saveJob(&job)
+	log.Printf("Deleted user %s", job.UserId)
+	return job
+}
+
+// resumeJobs runs every job that is not done, giving up on jobs that failed too often.
+func (d *DeletionHandler) resumeJobs() {
+	iter := d.FireStore.Collection("DeletionJobs").Where("status", "in", []string{jobPending, jobRunning, jobFailed}).Documents(context.Background())
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			return
+		}
+		var job DeletionJob
+		err = doc.DataTo(&job)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		if job.Attempts >= maxDeletionAttempts {
+			continue
+		}
+		log.Printf("Resuming deletion of %s", job.UserId)
+		d.runJob(job)
+	}
+}
+
+// Run resumes the jobs that were interrupted by a restart and retries failed
+// jobs on every tick.
+func (d *DeletionHandler) Run() {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = deletionJobsInterval
+	}
+	d.resumeJobs()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		d.resumeJobs()
+	}
+}
+
+// StatusWrapper returns the deletion job of the calling user.
+func (d *DeletionHandler) StatusWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, d.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	job, err := d.getJob(token.UID)
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get deletion job: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+	Handlers.WriteJSON(w, job.toResponse())
 }
 
 func (d *DeletionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -125,13 +374,13 @@ func (d *DeletionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d.moveUserRatings(token.UID)
-	d.moveUserData(token.UID)
-	d.removeUserFromFriends(token.UID)
-	//respond with 200 OK
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte("OK"))
+	job, err := d.startJob(token.UID)
 	if err != nil {
+		log.Printf("Failed to start deletion job: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	// The app follows the job on /delete/status, failed jobs are retried by Run
+	go d.runJob(job)
+	Handlers.WriteJSONStatus(w, http.StatusAccepted, job.toResponse())
 }
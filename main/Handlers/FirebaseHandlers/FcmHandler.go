@@ -11,6 +11,7 @@ import (
 	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -27,6 +28,7 @@ type FcmHandler struct {
 type RatingEvent struct {
 	UserID   string
 	MovieID  string
+	Season   int
 	DateTime time.Time
 	Multiple bool
 }
@@ -95,6 +97,13 @@ func (fcm *FcmHandler) SendNotification(token, content, link string) {
 	log.Printf("Successfully sent notification: %v", result)
 }
 
+// SendNotificationToUsers sends the notification to every given user that has a token.
+func (fcm *FcmHandler) SendNotificationToUsers(userIds []string, content, link string) {
+	for _, userId := range userIds {
+		fcm.SendNotification(fcm.getUserInfo(userId).FcmToken, content, link)
+	}
+}
+
 func (fcm *FcmHandler) sendNotificationToFriends(rating RatingEvent) {
 	user := fcm.getUserInfo(rating.UserID)
 	if user.Friends == nil {
@@ -110,6 +119,9 @@ func (fcm *FcmHandler) sendNotificationToFriends(rating RatingEvent) {
 	} else {
 		title = rating.MovieID
 	}
+	if rating.Season > 0 {
+		title = fmt.Sprintf("season %d of %s", rating.Season, title)
+	}
 
 	data, _ := json.Marshal(MessageData{Link: fmt.Sprintf("/profile/inspect/%s?from=/", rating.UserID)})
 	var content string
@@ -204,9 +216,28 @@ func (fcm *FcmHandler) RatedMovieWrapper(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	season := 0
+	if seasonParam := r.URL.Query().Get("season"); seasonParam != "" {
+		var err error
+		season, err = strconv.Atoi(seasonParam)
+		if err != nil || season < 1 {
+			http.Error(w, "Invalid season", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Rating a whole title takes it off the watchlist, rating a single season does not
+	if season == 0 {
+		_, err := fcm.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{{Path: "watchlist", Value: firestore.ArrayRemove(movieId)}})
+		if err != nil {
+			log.Printf("Failed to remove movie from watchlist: %v", err)
+		}
+	}
+
 	go fcm.handleRatingEvent(RatingEvent{
 		UserID:   token.UID,
 		MovieID:  movieId,
+		Season:   season,
 		DateTime: time.Now(),
 	})
 
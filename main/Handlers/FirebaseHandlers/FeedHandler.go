@@ -0,0 +1,234 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"encoding/base64"
+	"errors"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedSize = 20
+	maxFeedSize     = 50
+)
+
+var errInvalidFeedCursor = errors.New("invalid cursor")
+
+// FeedHandler serves the ratings and comments of a user's friends as a timeline.
+type FeedHandler struct {
+	AuthHandler  *auth.Client
+	FireStore    *firestore.Client
+	MongoHandler *MovieHandlers.MongoHandler
+}
+
+type feedItem struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	UserPicture string    `json:"userPicture"`
+	MovieId     string    `json:"movieId"`
+	Season      int       `json:"season,omitempty"`
+	Rating      float64   `json:"rating"`
+	Comment     string    `json:"comment,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Title       string    `json:"title,omitempty"`
+	Poster      string    `json:"poster,omitempty"`
+}
+
+type feedPage struct {
+	Items      []feedItem `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// feedCursor points behind the last item of a page. The document id breaks
+// ties between ratings with the same timestamp.
+type feedCursor struct {
+	timestamp time.Time
+	id        string
+}
+
+func (c feedCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.timestamp.Format(time.RFC3339Nano) + "|" + c.id))
+}
+
+func decodeFeedCursor(cursor string) (*feedCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidFeedCursor
+	}
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return nil, errInvalidFeedCursor
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errInvalidFeedCursor
+	}
+	return &feedCursor{timestamp: timestamp, id: parts[1]}, nil
+}
+
+func posterUrl(movie MovieHandlers.MovieResponse) string {
+	if poster, ok := movie.PosterURLs["342"]; ok {
+		return poster
+	}
+	return movie.PosterURLs["original"]
+}
+
+// fetchFeedRatings returns up to limit ratings of the given users before the
+// cursor, newest first, querying in chunks as "in" queries are limited.
+func (f *FeedHandler) fetchFeedRatings(userIds []string, cursor *feedCursor, limit int) ([]feedItem, error) {
+	items := make([]feedItem, 0)
+	for start := 0; start < len(userIds); start += firestoreInLimit {
+		end := start + firestoreInLimit
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+		query := f.FireStore.Collection("Ratings").
+			Where("userId", "in", userIds[start:end]).
+			OrderBy("timestamp", firestore.Desc).
+			OrderBy(firestore.DocumentID, firestore.Desc)
+		if cursor != nil {
+			query = query.StartAfter(cursor.timestamp, cursor.id)
+		}
+		docs, err := query.Limit(limit).Documents(context.Background()).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var rating Rating
+			err = doc.DataTo(&rating)
+			if err != nil {
+				log.Printf("Failed to convert data: %v", err)
+				continue
+			}
+			items = append(items, feedItem{
+				Id:        doc.Ref.ID,
+				UserId:    rating.UserId,
+				MovieId:   rating.MovieId,
+				Season:    rating.Season,
+				Rating:    rating.Rating,
+				Comment:   rating.Comment,
+				Timestamp: rating.Timestamp,
+			})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Timestamp.Equal(items[j].Timestamp) {
+			return items[i].Id > items[j].Id
+		}
+		return items[i].Timestamp.After(items[j].Timestamp)
+	})
+	return items, nil
+}
+
+// hydrate fills in the friends' names and the movies' titles and posters.
+func (f *FeedHandler) hydrate(items []feedItem) {
+	userRefs := make([]*firestore.DocumentRef, 0)
+	movieIds := make([]string, 0)
+	for _, item := range items {
+		if !Handlers.ArrayContains(movieIds, item.MovieId) {
+			movieIds = append(movieIds, item.MovieId)
+		}
+		ref := f.FireStore.Collection("Users").Doc(item.UserId)
+		duplicate := false
+		for _, userRef := range userRefs {
+			duplicate = duplicate || userRef.ID == ref.ID
+		}
+		if !duplicate {
+			userRefs = append(userRefs, ref)
+		}
+	}
+
+	users := make(map[string]User)
+	docs, err := f.FireStore.GetAll(context.Background(), userRefs)
+	if err != nil {
+		log.Printf("Failed to get users: %v", err)
+	}
+	for _, doc := range docs {
+		var user User
+		if doc.Exists() && doc.DataTo(&user) == nil {
+			users[doc.Ref.ID] = user
+		}
+	}
+	movies, err := f.MongoHandler.FetchManyFromCache(movieIds)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		movies = map[string]MovieHandlers.MovieResponse{}
+	}
+
+	for index := range items {
+		items[index].UserName = users[items[index].UserId].Name
+		items[index].UserPicture = users[items[index].UserId].Picture
+		if movie, ok := movies[items[index].MovieId]; ok {
+			items[index].Title = movie.Title
+			items[index].Poster = posterUrl(movie)
+		}
+	}
+}
+
+func (f *FeedHandler) feed(userId string, cursor *feedCursor, limit int) (feedPage, int, string) {
+	userDoc, err := f.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return feedPage{}, 404, "user doesn't exist"
+	}
+	var user User
+	err = userDoc.DataTo(&user)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return feedPage{}, 500, "Internal Server Error"
+	}
+	if len(user.Friends) == 0 {
+		return feedPage{Items: []feedItem{}}, 200, "Ok"
+	}
+
+	items, err := f.fetchFeedRatings(user.Friends, cursor, limit+1)
+	if err != nil {
+		log.Printf("Failed to get ratings: %v", err)
+		return feedPage{}, 500, "Internal Server Error"
+	}
+	page := feedPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		last := page.Items[limit-1]
+		page.NextCursor = feedCursor{timestamp: last.Timestamp, id: last.Id}.encode()
+	}
+	f.hydrate(page.Items)
+	return page, 200, "Ok"
+}
+
+func (f *FeedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, f.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	limit, ok := parseLimit(r, defaultFeedSize, maxFeedSize)
+	if !ok {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	cursor, err := decodeFeedCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, code, message := f.feed(token.UID, cursor, limit)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, page)
+}
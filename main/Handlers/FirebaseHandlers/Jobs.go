@@ -0,0 +1,35 @@
+package FirebaseHandlers
+
+import "sync"
+
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobLocks makes sure a job is only run once at a time by this server.
+type jobLocks struct {
+	mutex   sync.Mutex
+	running map[string]bool
+}
+
+func (l *jobLocks) claim(id string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.running == nil {
+		l.running = make(map[string]bool)
+	}
+	if l.running[id] {
+		return false
+	}
+	l.running[id] = true
+	return true
+}
+
+func (l *jobLocks) release(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.running, id)
+}
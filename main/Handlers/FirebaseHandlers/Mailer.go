@@ -0,0 +1,62 @@
+package FirebaseHandlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends plain text emails to users.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer returns a mailer sending through the given smtp server, or one
+// that drops the mails if no server is configured, which is only allowed
+// against the emulator. Locally any smtp
+// stand-in like mailpit on localhost:1025 can be used, it needs no username.
+func NewMailer(host, from, username, password string) Mailer {
+	if host == "" {
+		return LogMailer{}
+	}
+	return &SmtpMailer{Host: host, From: from, Username: username, Password: password}
+}
+
+type SmtpMailer struct {
+	Host     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SmtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		hostname, _, err := net.SplitHostPort(m.Host)
+		if err != nil {
+			return fmt.Errorf("invalid smtp host: %w", err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, hostname)
+	}
+	message := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(m.Host, auth, m.From, []string{to}, []byte(message))
+}
+
+// LogMailer logs that a mail would have been sent instead of sending it. The
+// body is left out, as it may carry a restore token.
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, _ string) error {
+	log.Printf("Not sending mail to %s: %s", to, subject)
+	return nil
+}
@@ -0,0 +1,173 @@
+package FirebaseHandlers
+
+import (
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultMovieNightPicks = 10
+	maxMovieNightPicks     = 50
+	// disagreementPenalty lowers the group score of titles the members would judge very differently.
+	disagreementPenalty = 0.5
+	// sharedPicks is the number of titles named in the notification to the group.
+	sharedPicks = 3
+)
+
+// groupScore predicts how much the group enjoys a title as the mean predicted
+// deviation of the members, penalized by how much they disagree.
+func groupScore(scores []float64) float64 {
+	mean := 0.0
+	for _, score := range scores {
+		mean += score
+	}
+	mean /= float64(len(scores))
+	variance := 0.0
+	for _, score := range scores {
+		variance += (score - mean) * (score - mean)
+	}
+	return mean - disagreementPenalty*math.Sqrt(variance/float64(len(scores)))
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !Handlers.ArrayContains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// pickMovieNight ranks cached titles none of the members rated that are
+// available on one of the services.
+func (t *TasteHandler) pickMovieNight(userId string, friendIds, services []string, country string, limit int) ([]recommendation, User, int, string) {
+	user, err := t.getUser(userId)
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return nil, User{}, 404, "user doesn't exist"
+	}
+	for _, friendId := range friendIds {
+		if !Handlers.ArrayContains(user.Friends, friendId) {
+			return nil, User{}, 403, fmt.Sprintf("Not friends with %s", friendId)
+		}
+	}
+	if country == "" {
+		country = userCountry(user)
+	}
+
+	members := append([]string{userId}, friendIds...)
+	ratings, err := t.fetchRatings(members)
+	if err != nil {
+		log.Printf("Failed to get ratings: %v", err)
+		return nil, User{}, 500, "Internal Server Error"
+	}
+	movies, err := t.fetchMovies()
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		return nil, User{}, 500, "Internal Server Error"
+	}
+	profiles := make([]tasteProfile, 0, len(members))
+	for _, member := range members {
+		profiles = append(profiles, buildTasteProfile(ratings[member], movies))
+	}
+
+	picks := make([]recommendation, 0)
+	for movieId, movie := range movies {
+		available := false
+		for _, service := range services {
+			available = available || movie.AvailableOn(country, service)
+		}
+		if !available {
+			continue
+		}
+		rated := false
+		scores := make([]float64, 0, len(profiles))
+		for _, profile := range profiles {
+			if _, ok := profile.ratings[movieId]; ok {
+				rated = true
+				break
+			}
+			scores = append(scores, profile.contentScore(movie))
+		}
+		if rated {
+			continue
+		}
+		picks = append(picks, recommendation{MovieId: movieId, Score: groupScore(scores), Movie: movie})
+	}
+	sort.Slice(picks, func(i, j int) bool {
+		if picks[i].Score == picks[j].Score {
+			return picks[i].Movie.TMDBRating > picks[j].Movie.TMDBRating
+		}
+		return picks[i].Score > picks[j].Score
+	})
+	if len(picks) > limit {
+		picks = picks[:limit]
+	}
+	for index := range picks {
+		picks[index].Movie = picks[index].Movie.ForCountry(country)
+	}
+	return picks, user, 200, "Ok"
+}
+
+// sharePicks sends the top picks to every friend of the group.
+func (t *TasteHandler) sharePicks(user User, friendIds []string, picks []recommendation) {
+	if len(picks) == 0 {
+		return
+	}
+	titles := make([]string, 0, sharedPicks)
+	for index := 0; index < len(picks) && index < sharedPicks; index++ {
+		titles = append(titles, picks[index].Movie.Title)
+	}
+	content := fmt.Sprintf("%s picked movies for your movie night: %s", user.Name, strings.Join(titles, ", "))
+	for _, friendId := range friendIds {
+		friend, err := t.getUser(friendId)
+		if err != nil {
+			log.Printf("Failed to get friend: %v", err)
+			continue
+		}
+		t.FcmHandler.SendNotification(friend.FcmToken, content, movieLink(picks[0].MovieId))
+	}
+}
+
+// MovieNightWrapper picks titles for the caller and the friends in the
+// friendIds parameter that are available on one of the services parameter.
+func (t *TasteHandler) MovieNightWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, t.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	friendIds := splitList(r.URL.Query().Get("friendIds"))
+	if len(friendIds) == 0 {
+		http.Error(w, "Missing friendIds", http.StatusBadRequest)
+		return
+	}
+	services := splitList(strings.ToLower(r.URL.Query().Get("services")))
+	if len(services) == 0 {
+		http.Error(w, "Missing services", http.StatusBadRequest)
+		return
+	}
+	limit, ok := parseLimit(r, defaultMovieNightPicks, maxMovieNightPicks)
+	if !ok {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	country := strings.ToLower(r.URL.Query().Get("country"))
+
+	picks, user, code, message := t.pickMovieNight(token.UID, friendIds, services, country, limit)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	if r.URL.Query().Get("share") == "true" {
+		go t.sharePicks(user, friendIds, picks)
+	}
+	Handlers.WriteJSON(w, picks)
+}
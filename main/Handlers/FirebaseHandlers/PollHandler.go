@@ -0,0 +1,374 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"firebase.google.com/go/v4/auth"
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	minPollCandidates = 2
+	maxPollCandidates = 10
+	maxPollDuration   = 30 * 24 * time.Hour
+)
+
+var (
+	errNotParticipant = errors.New("not invited to this poll")
+	errPollClosed     = errors.New("poll is closed")
+	errNoCandidate    = errors.New("movie is not a candidate of this poll")
+)
+
+// PollHandler lets users vote with their friends on what to watch.
+type PollHandler struct {
+	AuthHandler  *auth.Client
+	FireStore    *firestore.Client
+	MongoHandler *MovieHandlers.MongoHandler
+	FcmHandler   *FcmHandler
+	Interval     time.Duration
+}
+
+type pollCandidate struct {
+	MovieId string `json:"movieId"`
+	Title   string `json:"title,omitempty"`
+	Votes   int    `json:"votes"`
+}
+
+type pollResponse struct {
+	Id         string            `json:"id"`
+	CreatorId  string            `json:"creatorId"`
+	Title      string            `json:"title"`
+	Candidates []pollCandidate   `json:"candidates"`
+	Invitees   []string          `json:"invitees"`
+	Votes      map[string]string `json:"votes"`
+	Deadline   time.Time         `json:"deadline"`
+	Closed     bool              `json:"closed"`
+	Winner     string            `json:"winner,omitempty"`
+}
+
+func pollLink(pollId string) string {
+	return fmt.Sprintf("/polls/%s?from=/", pollId)
+}
+
+func (p Poll) participants() []string {
+	return append([]string{p.CreatorId}, p.Invitees...)
+}
+
+func (p Poll) isParticipant(userId string) bool {
+	return Handlers.ArrayContains(p.participants(), userId)
+}
+
+// tally counts the votes per candidate, in the order of the candidates.
+func (p Poll) tally() []pollCandidate {
+	candidates := make([]pollCandidate, 0, len(p.Candidates))
+	for _, movieId := range p.Candidates {
+		candidate := pollCandidate{MovieId: movieId}
+		for _, vote := range p.Votes {
+			if vote == movieId {
+				candidate.Votes++
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// winner returns the candidate with the most votes, ties going to the one proposed first.
+func (p Poll) winner() string {
+	winner, mostVotes := "", 0
+	for _, candidate := range p.tally() {
+		if candidate.Votes > mostVotes {
+			winner, mostVotes = candidate.MovieId, candidate.Votes
+		}
+	}
+	return winner
+}
+
+func (ph *PollHandler) toResponse(id string, poll Poll) pollResponse {
+	candidates := poll.tally()
+	movies, err := ph.MongoHandler.FetchManyFromCache(poll.Candidates)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+	}
+	for index := range candidates {
+		candidates[index].Title = movies[candidates[index].MovieId].Title
+	}
+	return pollResponse{
+		Id:         id,
+		CreatorId:  poll.CreatorId,
+		Title:      poll.Title,
+		Candidates: candidates,
+		Invitees:   poll.Invitees,
+		Votes:      poll.Votes,
+		Deadline:   poll.Deadline,
+		Closed:     poll.Closed,
+		Winner:     poll.Winner,
+	}
+}
+
+func (ph *PollHandler) createPoll(userId, title string, candidates, invitees []string, deadline time.Time) (string, int, string) {
+	if len(candidates) < minPollCandidates || len(candidates) > maxPollCandidates {
+		return "", 400, fmt.Sprintf("a poll needs %d to %d movies", minPollCandidates, maxPollCandidates)
+	}
+	if deadline.Before(time.Now()) || time.Until(deadline) > maxPollDuration {
+		return "", 400, "invalid deadline"
+	}
+	userDoc, err := ph.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return "", 404, "user doesn't exist"
+	}
+	var user User
+	err = userDoc.DataTo(&user)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return "", 500, "Internal Server Error"
+	}
+	for _, invitee := range invitees {
+		if !Handlers.ArrayContains(user.Friends, invitee) {
+			return "", 403, fmt.Sprintf("Not friends with %s", invitee)
+		}
+	}
+
+	poll := Poll{
+		CreatorId:  userId,
+		Title:      title,
+		Candidates: candidates,
+		Invitees:   invitees,
+		Votes:      map[string]string{},
+		Deadline:   deadline,
+		CreatedAt:  time.Now(),
+	}
+	ref, _, err := ph.FireStore.Collection("Polls").Add(context.Background(), poll)
+	if err != nil {
+		log.Printf("Failed to create poll: %v", err)
+		return "", 500, "Internal Server Error"
+	}
+	go ph.FcmHandler.SendNotificationToUsers(invitees, fmt.Sprintf("%s invited you to vote on a movie night", user.Name), pollLink(ref.ID))
+	return ref.ID, 200, "Ok"
+}
+
+// vote records the user's vote and notifies the participants once everyone voted.
+func (ph *PollHandler) vote(userId, pollId, movieId string) (int, string) {
+	ref := ph.FireStore.Collection("Polls").Doc(pollId)
+	var poll Poll
+	allVoted := false
+	err := ph.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		err = doc.DataTo(&poll)
+		if err != nil {
+			return err
+		}
+		if !poll.isParticipant(userId) {
+			return errNotParticipant
+		}
+		if poll.Closed || poll.Deadline.Before(time.Now()) {
+			return errPollClosed
+		}
+		if !Handlers.ArrayContains(poll.Candidates, movieId) {
+			return errNoCandidate
+		}
+
+		if poll.Votes == nil {
+			poll.Votes = map[string]string{}
+		}
+		poll.Votes[userId] = movieId
+		updates := []firestore.Update{{FieldPath: firestore.FieldPath{"votes", userId}, Value: movieId}}
+		allVoted = !poll.AllVotedNotified && len(poll.Votes) == len(poll.participants())
+		if allVoted {
+			updates = append(updates, firestore.Update{Path: "allVotedNotified", Value: true})
+		}
+		return tx.Update(ref, updates)
+	})
+	switch {
+	case err == errNotParticipant:
+		return 403, err.Error()
+	case err == errPollClosed:
+		return 409, err.Error()
+	case err == errNoCandidate:
+		return 400, err.Error()
+	case status.Code(err) == codes.NotFound:
+		return 404, "poll doesn't exist"
+	case err != nil:
+		log.Printf("Failed to vote: %v", err)
+		return 500, "Internal Server Error"
+	}
+
+	if allVoted {
+		go ph.FcmHandler.SendNotificationToUsers(poll.participants(), fmt.Sprintf("Everyone voted on %s", poll.Title), pollLink(pollId))
+	}
+	return 200, "Ok"
+}
+
+func (ph *PollHandler) Run() {
+	ticker := time.NewTicker(ph.Interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		ph.closeExpiredPolls()
+	}
+}
+
+// closeExpiredPolls closes the open polls whose deadline passed. The deadline
+// is checked here, as filtering on both fields would need a composite index.
+func (ph *PollHandler) closeExpiredPolls() {
+	now := time.Now()
+	iter := ph.FireStore.Collection("Polls").Where("closed", "==", false).Documents(context.Background())
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			return
+		}
+		var poll Poll
+		err = doc.DataTo(&poll)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		if poll.Deadline.After(now) {
+			continue
+		}
+		ph.closePoll(doc.Ref)
+	}
+}
+
+// closePoll picks the winner and notifies the participants.
+func (ph *PollHandler) closePoll(ref *firestore.DocumentRef) {
+	var poll Poll
+	err := ph.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		err = doc.DataTo(&poll)
+		if err != nil {
+			return err
+		}
+		if poll.Closed {
+			return errPollClosed
+		}
+		poll.Winner = poll.winner()
+		return tx.Update(ref, []firestore.Update{
+			{Path: "closed", Value: true},
+			{Path: "winner", Value: poll.Winner},
+		})
+	})
+	if err == errPollClosed {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to close poll: %v", err)
+		return
+	}
+
+	content := fmt.Sprintf("%s closed without votes", poll.Title)
+	if poll.Winner != "" {
+		title := poll.Winner
+		if movie, err := ph.MongoHandler.FetchFromCache(poll.Winner); err == nil && movie.Title != "" {
+			title = movie.Title
+		}
+		content = fmt.Sprintf("%s closed, you are watching %s", poll.Title, title)
+	}
+	ph.FcmHandler.SendNotificationToUsers(poll.participants(), content, pollLink(ref.ID))
+}
+
+// CreateWrapper creates a poll between the movieIds parameter for the friends in the friendIds parameter.
+func (ph *PollHandler) CreateWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, ph.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	candidates := splitList(r.URL.Query().Get("movieIds"))
+	invitees := splitList(r.URL.Query().Get("friendIds"))
+	if len(invitees) == 0 {
+		http.Error(w, "Missing friendIds", http.StatusBadRequest)
+		return
+	}
+	deadline, err := time.Parse(time.RFC3339, r.URL.Query().Get("deadline"))
+	if err != nil {
+		http.Error(w, "Invalid deadline", http.StatusBadRequest)
+		return
+	}
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		title = "Movie night"
+	}
+
+	pollId, code, message := ph.createPoll(token.UID, title, candidates, invitees, deadline)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, map[string]string{"id": pollId})
+}
+
+func (ph *PollHandler) VoteWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, ph.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	pollId := r.URL.Query().Get("pollId")
+	movieId := r.URL.Query().Get("movieId")
+	if pollId == "" || movieId == "" {
+		http.Error(w, "Missing pollId or movieId", http.StatusBadRequest)
+		return
+	}
+
+	code, message := ph.vote(token.UID, pollId, movieId)
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (ph *PollHandler) GetWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, ph.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	pollId := r.URL.Query().Get("pollId")
+	if pollId == "" {
+		http.Error(w, "Missing pollId", http.StatusBadRequest)
+		return
+	}
+	doc, err := ph.FireStore.Collection("Polls").Doc(pollId).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "poll doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get poll: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var poll Poll
+	err = doc.DataTo(&poll)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !poll.isParticipant(token.UID) {
+		http.Error(w, errNotParticipant.Error(), http.StatusForbidden)
+		return
+	}
+	Handlers.WriteJSON(w, ph.toResponse(doc.Ref.ID, poll))
+}
@@ -0,0 +1,98 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ProfileHandler struct {
+	AuthHandler *auth.Client
+	FireStore   *firestore.Client
+}
+
+// CountryForRequest returns the country stored in the profile of the user
+// making the request, or an empty string for anonymous requests.
+func (p *ProfileHandler) CountryForRequest(r *http.Request) string {
+	idToken := r.Header.Get("Authorization")
+	if idToken == "" {
+		return ""
+	}
+	token, err := p.AuthHandler.VerifyIDToken(context.Background(), idToken)
+	if err != nil {
+		log.Printf("error verifying ID token: %v\n", err)
+		return ""
+	}
+	user, err := p.FireStore.Collection("Users").Doc(token.UID).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return ""
+	}
+	var userData User
+	err = user.DataTo(&userData)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return ""
+	}
+	return userData.Country
+}
+
+func (p *ProfileHandler) SetCountryWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, p.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	country := strings.ToLower(r.URL.Query().Get("country"))
+	if len(country) != 2 {
+		http.Error(w, "Invalid country", http.StatusBadRequest)
+		return
+	}
+
+	_, err := p.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{{Path: "country", Value: country}})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte("OK"))
+	if err != nil {
+		log.Printf("Failed to write response: %v", err)
+		return
+	}
+}
+
+// SetServicesWrapper replaces the streaming services the user subscribes to
+// with the comma separated services parameter.
+func (p *ProfileHandler) SetServicesWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, p.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	services := make([]string, 0)
+	for _, service := range strings.Split(r.URL.Query().Get("services"), ",") {
+		service = strings.ToLower(strings.TrimSpace(service))
+		if service != "" && !Handlers.ArrayContains(services, service) {
+			services = append(services, service)
+		}
+	}
+
+	_, err := p.FireStore.Collection("Users").Doc(token.UID).Update(context.Background(), []firestore.Update{{Path: "streamingServices", Value: services}})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte("OK"))
+	if err != nil {
+		log.Printf("Failed to write response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,210 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	profilesPrefix         = "Profiles/"
+	archivedProfilesPrefix = "ArchivedProfiles/"
+	expiresAtMetadata      = "expiresAt"
+)
+
+// ProfilePictures moves the uploaded profile pictures of deleted accounts out
+// of Profiles/{userId}/ into ArchivedProfiles/{userId}/, which the storage
+// rules keep private, and back again on restore.
+type ProfilePictures struct {
+	Bucket *storage.BucketHandle
+}
+
+func (p *ProfilePictures) list(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+	iter := p.Bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+}
+
+// move copies every object below from to the same name below to and deletes
+// the original afterwards, so an interrupted move can simply be repeated.
+// It returns the names of the moved objects below to.
+func (p *ProfilePictures) move(ctx context.Context, from, to string, metadata func(map[string]string) map[string]string) ([]string, error) {
+	objects, err := p.list(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", from, err)
+	}
+	var moved []string
+	for _, object := range objects {
+		name := to + strings.TrimPrefix(object.Name, from)
+		copier := p.Bucket.Object(name).CopierFrom(p.Bucket.Object(object.Name))
+		copier.ContentType = object.ContentType
+		copier.Metadata = metadata(object.Metadata)
+		_, err = copier.Run(ctx)
+		if err != nil {
+			return moved, fmt.Errorf("failed to copy %s: %w", object.Name, err)
+		}
+		err = p.Bucket.Object(object.Name).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return moved, fmt.Errorf("failed to delete %s: %w", object.Name, err)
+		}
+		moved = append(moved, name)
+	}
+	return moved, nil
+}
+
+// Archive moves the pictures of the user into the archive until expiresAt.
+func (p *ProfilePictures) Archive(userId string, expiresAt time.Time) error {
+	if p == nil {
+		return nil
+	}
+	moved, err := p.move(context.Background(), profilesPrefix+userId+"/", archivedProfilesPrefix+userId+"/", func(metadata map[string]string) map[string]string {
+		archived := map[string]string{expiresAtMetadata: expiresAt.Format(time.RFC3339)}
+		for key, value := range metadata {
+			if key != expiresAtMetadata {
+				archived[key] = value
+			}
+		}
+		return archived
+	})
+	log.Printf("Archived %d profile pictures of %s", len(moved), userId)
+	return err
+}
+
+// Restore moves the archived pictures of oldUserId to the profile of
+// newUserId and returns the names of all pictures the profile now has, which
+// includes pictures moved by an earlier, interrupted restore. The download
+// tokens are kept, so existing download urls only need their path changed.
+func (p *ProfilePictures) Restore(oldUserId, newUserId string) ([]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+	ctx := context.Background()
+	_, err := p.move(ctx, archivedProfilesPrefix+oldUserId+"/", profilesPrefix+newUserId+"/", func(metadata map[string]string) map[string]string {
+		restored := map[string]string{}
+		for key, value := range metadata {
+			if key != expiresAtMetadata {
+				restored[key] = value
+			}
+		}
+		return restored
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects, err := p.list(ctx, profilesPrefix+newUserId+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restored pictures: %w", err)
+	}
+	var names []string
+	for _, object := range objects {
+		names = append(names, object.Name)
+	}
+	return names, nil
+}
+
+// pictureObject returns the name of the storage object a firebase storage
+// download url points to.
+func pictureObject(picture string) string {
+	parsed, err := url.Parse(picture)
+	if err != nil {
+		return ""
+	}
+	_, object, found := strings.Cut(parsed.EscapedPath(), "/o/")
+	if !found {
+		return ""
+	}
+	name, err := url.PathUnescape(object)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// restoredPicture points the download url of an archived picture to its
+// restored object, and returns an empty url if the picture was not restored.
+func restoredPicture(picture, oldUserId, newUserId string, restored []string) string {
+	if !strings.HasPrefix(picture, "https://firebasestorage") {
+		return picture
+	}
+	object := pictureObject(picture)
+	if !strings.HasPrefix(object, profilesPrefix+oldUserId+"/") {
+		return ""
+	}
+	name := profilesPrefix + newUserId + "/" + strings.TrimPrefix(object, profilesPrefix+oldUserId+"/")
+	for _, restoredName := range restored {
+		if restoredName == name {
+			base, _, _ := strings.Cut(picture, "/o/")
+			_, query, _ := strings.Cut(picture, "?")
+			return base + "/o/" + url.PathEscape(name) + "?" + query
+		}
+	}
+	return ""
+}
+
+// expiredArchives returns the users whose archived pictures expired before
+// now, with the time they expired at.
+func (p *ProfilePictures) expiredArchives(now time.Time) (map[string]time.Time, error) {
+	expired := map[string]time.Time{}
+	if p == nil {
+		return expired, nil
+	}
+	objects, err := p.list(context.Background(), archivedProfilesPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archived pictures: %w", err)
+	}
+	for _, object := range objects {
+		userId, _, found := strings.Cut(strings.TrimPrefix(object.Name, archivedProfilesPrefix), "/")
+		if !found {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, object.Metadata[expiresAtMetadata])
+		if err != nil {
+			log.Printf("Archived picture %s has no valid expiry: %v", object.Name, err)
+			continue
+		}
+		if !expiresAt.After(now) {
+			expired[userId] = expiresAt
+		}
+	}
+	return expired, nil
+}
+
+// purge deletes the archived pictures of the user and returns how many there
+// were. In a dry run nothing is deleted.
+func (p *ProfilePictures) purge(userId string, dryRun bool) (int, error) {
+	if p == nil {
+		return 0, nil
+	}
+	ctx := context.Background()
+	objects, err := p.list(ctx, archivedProfilesPrefix+userId+"/")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list archived pictures: %w", err)
+	}
+	if dryRun {
+		return len(objects), nil
+	}
+	deleted := 0
+	for _, object := range objects {
+		err = p.Bucket.Object(object.Name).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return deleted, fmt.Errorf("failed to delete %s: %w", object.Name, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,49 @@
+package FirebaseHandlers
+
+import "testing"
+
+const pictureBase = "https://firebasestorage.googleapis.com/v0/b/project.appspot.com/o/"
+
+func TestPictureObject(t *testing.T) {
+	tests := map[string]string{
+		pictureBase + "Profiles%2Fold%2Fpicture.jpg?alt=media&token=abc": "Profiles/old/picture.jpg",
+		pictureBase + "Profiles%2Fold%2Fmy%20picture.jpg":                "Profiles/old/my picture.jpg",
+		"https://example.com/picture.jpg":                                "",
+		"://invalid":                                                     "",
+	}
+	for picture, want := range tests {
+		if got := pictureObject(picture); got != want {
+			t.Errorf("pictureObject(%q) = %q, want %q", picture, got, want)
+		}
+	}
+}
+
+func TestRestoredPicture(t *testing.T) {
+	restored := []string{"Profiles/new/picture.jpg"}
+	tests := map[string]struct {
+		picture string
+		want    string
+	}{
+		"restored": {
+			pictureBase + "Profiles%2Fold%2Fpicture.jpg?alt=media&token=abc",
+			pictureBase + "Profiles%2Fnew%2Fpicture.jpg?alt=media&token=abc",
+		},
+		"not restored": {
+			pictureBase + "Profiles%2Fold%2Fother.jpg?alt=media&token=abc",
+			"",
+		},
+		"of another user": {
+			pictureBase + "Profiles%2Fsomeone%2Fpicture.jpg?alt=media&token=abc",
+			"",
+		},
+		"from a sign-in provider": {
+			"https://lh3.googleusercontent.com/a/picture",
+			"https://lh3.googleusercontent.com/a/picture",
+		},
+	}
+	for name, test := range tests {
+		if got := restoredPicture(test.picture, "old", "new", restored); got != test.want {
+			t.Errorf("%s: restoredPicture = %q, want %q", name, got, test.want)
+		}
+	}
+}
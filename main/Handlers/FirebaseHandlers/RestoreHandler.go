@@ -3,173 +3,720 @@ package FirebaseHandlers
 import (
 	"cloud.google.com/go/firestore"
 	"context"
+	"errors"
 	"firebase.google.com/go/v4/auth"
+	"fmt"
 	"github.com/ItzBubschki/mr-backend/main/Handlers"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 )
 
+const (
+	stepRatedMovies = "ratedMovies"
+	stepAuthRecord  = "authRecord"
+
+	restoreBatchSize    = 200
+	maxRestoreAttempts  = 5
+	restoreJobsInterval = 10 * time.Minute
+	// restoreMailInterval is how often the restore mail of an archive may be resent.
+	restoreMailInterval = time.Hour
+	// resendCallerInterval is how often a caller may ask to resend a restore mail.
+	resendCallerInterval = time.Minute
+)
+
+// restoreSteps are run in this order, every step can safely be run again.
+// The user and ratings steps save their progress on the job in the same
+// transaction as their writes, so the report never misses anything.
+var restoreSteps = []string{stepUser, stepRatings, stepRatedMovies, stepPictures, stepAuthRecord}
+
+var errArchiveGone = errors.New("archived account doesn't exist anymore")
+
+// RestoreHandler brings back archived accounts. Restoring needs the token
+// mailed on deletion and works onto any account, including a new one using a
+// different sign-in provider. Every restore is persisted as a job in
+// RestoreJobs, keyed by the id of the account the archive is restored onto,
+// so that Run can pick up jobs that did not finish.
 type RestoreHandler struct {
 	AuthHandler *auth.Client
 	FireStore   *firestore.Client
+	Pictures    *ProfilePictures
+	Tokens      *RestoreTokens
+	Mailer      Mailer
+	Interval    time.Duration
+	jobs        jobLocks
+	resends     jobLocks
 }
 
-func (rh *RestoreHandler) retrieveOldUserData(email string) (string, []string) {
-	docs, err := rh.FireStore.Collection("ArchivedUsers").Where("email", "==", email).Documents(context.Background()).GetAll()
-	if err != nil {
-		log.Printf("Failed to get user: %v", err)
-		return "", nil
+type RestoreJob struct {
+	UserId         string            `firestore:"userId"`
+	ArchivedUserId string            `firestore:"archivedUserId"`
+	Status         string            `firestore:"status"`
+	Steps          map[string]string `firestore:"steps"`
+	Error          string            `firestore:"error,omitempty"`
+	Attempts       int               `firestore:"attempts"`
+	Recovered      RestoreReport     `firestore:"recovered"`
+	CreatedAt      time.Time         `firestore:"createdAt"`
+	UpdatedAt      time.Time         `firestore:"updatedAt"`
+}
+
+// RestoreReport lists what a restore recovered. Skipped holds the users the
+// archived account was connected to that deleted their own account since.
+type RestoreReport struct {
+	Ratings          int      `firestore:"ratings" json:"ratings"`
+	RatedMovies      int      `firestore:"ratedMovies" json:"ratedMovies"`
+	Friends          []string `firestore:"friends" json:"friends"`
+	FriendRequests   []string `firestore:"friendRequests" json:"friendRequests"`
+	OutgoingRequests []string `firestore:"outgoingRequests" json:"outgoingRequests"`
+	Skipped          []string `firestore:"skipped" json:"skipped"`
+	Picture          bool     `firestore:"picture" json:"picture"`
+}
+
+type restoreJobResponse struct {
+	Status    string            `json:"status"`
+	Steps     map[string]string `json:"steps"`
+	Error     string            `json:"error,omitempty"`
+	Recovered RestoreReport     `json:"recovered"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// toResponse leaves out the error of a failed job, which is only logged,
+// unless the archive is gone and the job is not retried anymore.
+func (job RestoreJob) toResponse() restoreJobResponse {
+	response := restoreJobResponse{
+		Status:    job.Status,
+		Steps:     job.Steps,
+		Recovered: job.Recovered,
+		UpdatedAt: job.UpdatedAt,
 	}
-	if len(docs) == 0 {
-		return "", nil
+	if job.Status == jobFailed {
+		response.Error = "Restore failed, it is retried automatically"
+		if strings.Contains(job.Error, errArchiveGone.Error()) {
+			response.Error = errArchiveGone.Error()
+		}
 	}
-	var user User
-	err = docs[0].DataTo(&user)
-	if err != nil {
-		log.Printf("Failed to convert data: %v", err)
-		return "", nil
+	return response
+}
+
+// finished reports whether the job won't run anymore, either because it is
+// done or because it failed for good. A new restore may replace it.
+func (job RestoreJob) finished() bool {
+	if job.Status == jobDone {
+		return true
 	}
-	return docs[0].Ref.ID, user.Friends
+	return job.Status == jobFailed && (job.Attempts >= maxRestoreAttempts || strings.Contains(job.Error, errArchiveGone.Error()))
 }
 
-func (rh *RestoreHandler) restoreUserData(newUserId, oldUserId string) {
-	userDoc := rh.FireStore.Collection("ArchivedUsers").Doc(oldUserId)
-	userData, err := userDoc.Get(context.Background())
+// archivedUser verifies the restore token and returns the archived user it was issued for.
+func (rh *RestoreHandler) archivedUser(token string) (string, int, string) {
+	oldUserId, err := rh.Tokens.Verify(token)
 	if err != nil {
-		log.Printf("Failed to get user: %v", err)
-		return
+		return "", 403, err.Error()
+	}
+	_, err = rh.FireStore.Collection("ArchivedUsers").Doc(oldUserId).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return "", 404, errArchiveGone.Error()
 	}
-	var user User
-	err = userData.DataTo(&user)
 	if err != nil {
-		log.Printf("Failed to convert data: %v", err)
-		return
+		log.Printf("Failed to get archived user: %v", err)
+		return "", 500, "Internal Server Error"
+	}
+	return oldUserId, 200, "Ok"
+}
+
+func (rh *RestoreHandler) jobRef(userId string) *firestore.DocumentRef {
+	return rh.FireStore.Collection("RestoreJobs").Doc(userId)
+}
+
+// existingUsers returns the given users that still have an account.
+func existingUsers(tx *firestore.Transaction, users *firestore.CollectionRef, userIds []string) ([]string, error) {
+	if len(userIds) == 0 {
+		return nil, nil
 	}
-	if strings.HasPrefix(user.Picture, "https://firebasestorage") {
-		user.Picture = ""
+	refs := make([]*firestore.DocumentRef, 0, len(userIds))
+	for _, userId := range userIds {
+		refs = append(refs, users.Doc(userId))
 	}
-	_, err = rh.FireStore.Collection("Users").Doc(newUserId).Set(context.Background(), user, firestore.MergeAll)
+	docs, err := tx.GetAll(refs)
 	if err != nil {
-		log.Printf("Failed to restore user: %v", err)
-		return
+		return nil, err
+	}
+	var existing []string
+	for _, doc := range docs {
+		if doc.Exists() {
+			existing = append(existing, doc.Ref.ID)
+		}
 	}
-	_, err = userDoc.Delete(context.Background())
-	return
+	return existing, nil
 }
 
-func (rh *RestoreHandler) restoreRatings(newUserId, oldUserId string) {
-	query := rh.FireStore.Collection("ArchivedRatings").Where("userId", "==", oldUserId)
-	ratingsCollection := rh.FireStore.Collection("Ratings")
-	err := rh.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
-		iter := tx.Documents(query)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
+// restoredFields are the fields of the archived user written onto the
+// account, leaving out empty ones like the User type does. The email and
+// token of an account that signed in already are kept, and the lists are
+// merged into the ones the account has already.
+func restoredFields(user User, current *firestore.DocumentSnapshot) map[string]interface{} {
+	fields := map[string]interface{}{
+		"name":    user.Name,
+		"picture": user.Picture,
+	}
+	if !current.Exists() {
+		fields["email"] = user.Email
+	}
+	if user.Country != "" {
+		fields["country"] = user.Country
+	}
+	lists := map[string][]string{
+		"friends":           user.Friends,
+		"friendRequests":    user.FriendRequests,
+		"outgoingRequests":  user.OutgoingRequests,
+		"watchlist":         user.Watchlist,
+		"streamingServices": user.StreamingServices,
+		"followedTitles":    user.FollowedTitles,
+	}
+	for field, list := range lists {
+		if len(list) > 0 {
+			elements := make([]interface{}, 0, len(list))
+			for _, element := range list {
+				elements = append(elements, element)
 			}
+			fields[field] = firestore.ArrayUnion(elements...)
+		}
+	}
+	if len(user.PriceAlerts) > 0 {
+		fields["priceAlerts"] = user.PriceAlerts
+	}
+	return fields
+}
+
+// restoreUserData restores the user document together with both sides of its
+// friendships and friend requests in one transaction. Connections to users
+// that deleted their account in the meantime are skipped.
+func (rh *RestoreHandler) restoreUserData(job *RestoreJob) error {
+	users := rh.FireStore.Collection("Users")
+	archivedRef := rh.FireStore.Collection("ArchivedUsers").Doc(job.ArchivedUserId)
+	userRef := users.Doc(job.UserId)
+	var updated RestoreJob
+	err := rh.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		updated = *job
+		archived, err := tx.Get(archivedRef)
+		if status.Code(err) == codes.NotFound {
+			return errArchiveGone
+		}
+		if err != nil {
+			return err
+		}
+		var user User
+		err = archived.DataTo(&user)
+		if err != nil {
+			return err
+		}
+		current, err := tx.Get(userRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		report := RestoreReport{}
+		connections := map[string]*[]string{
+			"friends":          &user.Friends,
+			"friendRequests":   &user.FriendRequests,
+			"outgoingRequests": &user.OutgoingRequests,
+		}
+		for _, field := range []string{"friends", "friendRequests", "outgoingRequests"} {
+			userIds := *connections[field]
+			existing, err := existingUsers(tx, users, userIds)
 			if err != nil {
-				log.Printf("Failed to iterate: %v", err)
 				return err
 			}
-			var rating Rating
-			err = doc.DataTo(&rating)
-			if err != nil {
-				log.Printf("Failed to convert data: %v", err)
-				return err
+			for _, userId := range userIds {
+				if !Handlers.ArrayContains(existing, userId) && !Handlers.ArrayContains(report.Skipped, userId) {
+					report.Skipped = append(report.Skipped, userId)
+				}
 			}
-			log.Printf("Restoring rating: %v", doc.Ref.ID)
-			rating.UserId = newUserId
-			_, err = ratingsCollection.Doc(doc.Ref.ID).Set(ctx, rating)
-			if err != nil {
-				log.Printf("Failed to restore rating: %v", err)
-				return err
+			*connections[field] = existing
+		}
+		report.Friends = user.Friends
+		report.FriendRequests = user.FriendRequests
+		report.OutgoingRequests = user.OutgoingRequests
+
+		err = tx.Set(userRef, restoredFields(user, current), firestore.MergeAll)
+		if err != nil {
+			return err
+		}
+		// The other side of every connection, named from their point of view
+		counterparts := map[string]string{
+			"friends":          "friends",
+			"friendRequests":   "outgoingRequests",
+			"outgoingRequests": "friendRequests",
+		}
+		for field, counterpart := range counterparts {
+			for _, userId := range *connections[field] {
+				err = tx.Update(users.Doc(userId), []firestore.Update{{Path: counterpart, Value: firestore.ArrayUnion(job.UserId)}})
+				if err != nil {
+					return err
+				}
 			}
-			err = tx.Delete(doc.Ref)
+		}
+		err = tx.Delete(archivedRef)
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(rh.FireStore.Collection("DeletionJobs").Doc(job.ArchivedUserId))
+		if err != nil {
+			return err
+		}
+
+		ratings := updated.Recovered.Ratings
+		updated.Recovered = report
+		updated.Recovered.Ratings = ratings
+		updated.Steps = copySteps(job.Steps)
+		updated.Steps[stepUser] = jobDone
+		updated.UpdatedAt = time.Now()
+		return tx.Set(rh.jobRef(job.UserId), updated)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	*job = updated
+	return nil
+}
+
+func copySteps(steps map[string]string) map[string]string {
+	copied := make(map[string]string, len(steps))
+	for step, stepStatus := range steps {
+		copied[step] = stepStatus
+	}
+	return copied
+}
+
+// restoreRatings moves the ratings back in batches, each batch in its own
+// transaction together with the count on the job.
+func (rh *RestoreHandler) restoreRatings(job *RestoreJob) error {
+	query := rh.FireStore.Collection("ArchivedRatings").Where("userId", "==", job.ArchivedUserId).Limit(restoreBatchSize)
+	ratingsCollection := rh.FireStore.Collection("Ratings")
+	for {
+		var updated RestoreJob
+		err := rh.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+			updated = *job
+			updated.Steps = copySteps(job.Steps)
+			docs, err := tx.Documents(query).GetAll()
 			if err != nil {
-				log.Printf("Failed to delete rating: %v", err)
 				return err
 			}
+			for _, doc := range docs {
+				var rating Rating
+				err = doc.DataTo(&rating)
+				if err != nil {
+					return err
+				}
+				log.Printf("Restoring rating: %v", doc.Ref.ID)
+				rating.UserId = job.UserId
+				rating.ExpiresAt = time.Time{}
+				err = tx.Set(ratingsCollection.Doc(doc.Ref.ID), rating)
+				if err != nil {
+					return err
+				}
+				err = tx.Delete(doc.Ref)
+				if err != nil {
+					return err
+				}
+			}
+			updated.Recovered.Ratings += len(docs)
+			if len(docs) < restoreBatchSize {
+				updated.Steps[stepRatings] = jobDone
+			}
+			updated.UpdatedAt = time.Now()
+			return tx.Set(rh.jobRef(job.UserId), updated)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore ratings: %w", err)
 		}
-		return nil
+		*job = updated
+		if job.Steps[stepRatings] == jobDone {
+			return nil
+		}
+	}
+}
+
+// rewriteRatedMovies sets ratedMovies to the titles the user has ratings for,
+// ordered by when they were first rated.
+func (rh *RestoreHandler) rewriteRatedMovies(job *RestoreJob) error {
+	docs, err := rh.FireStore.Collection("Ratings").Where("userId", "==", job.UserId).Documents(context.Background()).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to get ratings: %w", err)
+	}
+	ratings := make([]Rating, 0, len(docs))
+	for _, doc := range docs {
+		var rating Rating
+		err = doc.DataTo(&rating)
+		if err != nil {
+			return fmt.Errorf("failed to convert rating: %w", err)
+		}
+		ratings = append(ratings, rating)
+	}
+	sort.SliceStable(ratings, func(i, j int) bool {
+		return ratings[i].Timestamp.Before(ratings[j].Timestamp)
 	})
+	ratedMovies := []string{}
+	for _, rating := range ratings {
+		if !Handlers.ArrayContains(ratedMovies, rating.MovieId) {
+			ratedMovies = append(ratedMovies, rating.MovieId)
+		}
+	}
+	_, err = rh.FireStore.Collection("Users").Doc(job.UserId).Update(context.Background(), []firestore.Update{{Path: "ratedMovies", Value: ratedMovies}})
+	if err != nil {
+		return fmt.Errorf("failed to rewrite ratedMovies: %w", err)
+	}
+	job.Recovered.RatedMovies = len(ratedMovies)
+	return nil
+}
 
+// restorePicture brings back an uploaded profile picture, or clears the
+// picture if it is gone.
+func (rh *RestoreHandler) restorePicture(job *RestoreJob) error {
+	userRef := rh.FireStore.Collection("Users").Doc(job.UserId)
+	userDoc, err := userRef.Get(context.Background())
 	if err != nil {
-		log.Printf("Failed to restore ratings: %v", err)
-		return
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	var user User
+	err = userDoc.DataTo(&user)
+	if err != nil {
+		return fmt.Errorf("failed to convert user: %w", err)
+	}
+	if !strings.HasPrefix(user.Picture, "https://firebasestorage") {
+		return nil
 	}
+	restored, err := rh.Pictures.Restore(job.ArchivedUserId, job.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to restore profile pictures: %w", err)
+	}
+	picture := restoredPicture(user.Picture, job.ArchivedUserId, job.UserId, restored)
+	_, err = userRef.Update(context.Background(), []firestore.Update{{Path: "picture", Value: picture}})
+	if err != nil {
+		return fmt.Errorf("failed to update picture: %w", err)
+	}
+	job.Recovered.Picture = picture != ""
+	return nil
 }
 
-func (rh *RestoreHandler) restoreUserFriends(newUserId string, friends []string) {
-	for _, friend := range friends {
-		doc := rh.FireStore.Collection("Users").Doc(friend)
-		_, err := doc.Update(context.Background(), []firestore.Update{
-			{
-				Path:  "friends",
-				Value: firestore.ArrayUnion(newUserId),
-			},
-		})
-		if err != nil {
-			log.Printf("Failed to restore friend: %v", err)
-		}
+// deleteOldAuthRecord removes the sign-in of the archived account once it was
+// restored onto a different one, so signing in with it does not start an
+// empty account.
+func (rh *RestoreHandler) deleteOldAuthRecord(job *RestoreJob) error {
+	if job.ArchivedUserId == job.UserId {
+		return nil
 	}
+	err := rh.AuthHandler.DeleteUser(context.Background(), job.ArchivedUserId)
+	if err != nil && !auth.IsUserNotFound(err) {
+		return fmt.Errorf("failed to delete old auth record: %w", err)
+	}
+	return nil
 }
 
-func (rh *RestoreHandler) checkIfRestoreAvailable(email string) bool {
-	id, _ := rh.retrieveOldUserData(email)
-	return id != ""
+func (rh *RestoreHandler) runStep(job *RestoreJob, step string) error {
+	switch step {
+	case stepUser:
+		return rh.restoreUserData(job)
+	case stepRatings:
+		return rh.restoreRatings(job)
+	case stepRatedMovies:
+		return rh.rewriteRatedMovies(job)
+	case stepPictures:
+		return rh.restorePicture(job)
+	case stepAuthRecord:
+		return rh.deleteOldAuthRecord(job)
+	}
+	return fmt.Errorf("unknown step %s", step)
 }
 
-func (rh *RestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		available := rh.checkIfRestoreAvailable(r.URL.Query().Get("email"))
-		if available {
-			_, err := w.Write([]byte("OK"))
-			if err != nil {
-				log.Printf("Failed to write response: %v", err)
-			}
-			return
-		} else {
-			w.WriteHeader(http.StatusNotFound)
-			_, err := w.Write([]byte("Not found"))
-			if err != nil {
-				log.Printf("Failed to write response: %v", err)
+// startJob creates the job restoring the archive of oldUserId onto the user,
+// or returns the existing job of the user if it did not finish yet.
+func (rh *RestoreHandler) startJob(userId, oldUserId string) (RestoreJob, error) {
+	ref := rh.jobRef(userId)
+	var job RestoreJob
+	err := rh.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err == nil {
+			err = doc.DataTo(&job)
+			if err != nil || !job.finished() {
+				return err
 			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		job = RestoreJob{
+			UserId:         userId,
+			ArchivedUserId: oldUserId,
+			Status:         jobPending,
+			Steps:          map[string]string{},
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		for _, step := range restoreSteps {
+			job.Steps[step] = jobPending
+		}
+		return tx.Set(ref, job)
+	})
+	return job, err
+}
+
+func (rh *RestoreHandler) getJob(userId string) (RestoreJob, error) {
+	doc, err := rh.jobRef(userId).Get(context.Background())
+	if err != nil {
+		return RestoreJob{}, err
+	}
+	var job RestoreJob
+	err = doc.DataTo(&job)
+	return job, err
+}
+
+func (rh *RestoreHandler) saveJob(job *RestoreJob) {
+	job.UpdatedAt = time.Now()
+	_, err := rh.jobRef(job.UserId).Set(context.Background(), *job)
+	if err != nil {
+		log.Printf("Failed to save restore job of %s: %v", job.UserId, err)
+	}
+}
+
+// runJob runs all steps that are not done yet and stops at the first failing one.
+func (rh *RestoreHandler) runJob(job RestoreJob) RestoreJob {
+	if job.Status == jobDone || !rh.jobs.claim(job.UserId) {
+		return job
+	}
+	defer rh.jobs.release(job.UserId)
+
+	job.Status = jobRunning
+	job.Error = ""
+	job.Attempts++
+	rh.saveJob(&job)
+	for _, step := range restoreSteps {
+		if job.Steps[step] == jobDone {
+			continue
+		}
+		err := rh.runStep(&job, step)
+		if err != nil {
+			log.Printf("Restore of %s onto %s failed at step %s: %v", job.ArchivedUserId, job.UserId, step, err)
+			job.Steps[step] = jobFailed
+			job.Status = jobFailed
+			job.Error = err.Error()
+			rh.saveJob(&job)
+			return job
+		}
+		job.Steps[step] = jobDone
+		rh.saveJob(&job)
+	}
+	job.Status = jobDone
+	rh.saveJob(&job)
+	log.Printf("Restored %s onto %s", job.ArchivedUserId, job.UserId)
+	return job
+}
+
+// resumeJobs runs every job that is not done, giving up on jobs that failed
+// too often or whose archive is gone.
+func (rh *RestoreHandler) resumeJobs() {
+	iter := rh.FireStore.Collection("RestoreJobs").Where("status", "in", []string{jobPending, jobRunning, jobFailed}).Documents(context.Background())
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
 			return
 		}
+		var job RestoreJob
+		err = doc.DataTo(&job)
+		if err != nil {
+			log.Printf("Failed to convert data: %v", err)
+			continue
+		}
+		if job.finished() {
+			continue
+		}
+		log.Printf("Resuming restore of %s onto %s", job.ArchivedUserId, job.UserId)
+		rh.runJob(job)
+	}
+}
+
+// Run resumes the jobs that were interrupted by a restart and retries failed
+// jobs on every tick.
+func (rh *RestoreHandler) Run() {
+	interval := rh.Interval
+	if interval <= 0 {
+		interval = restoreJobsInterval
 	}
+	rh.resumeJobs()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		rh.resumeJobs()
+	}
+}
 
+// ResendWrapper mails a new restore token to the email of an archived
+// account. Restoring works onto any account, so the caller only needs to be
+// signed in with one. It answers the same whether an archive exists or not,
+// so it does not reveal which emails had an account. Every caller may ask
+// once per resendCallerInterval.
+func (rh *RestoreHandler) ResendWrapper(w http.ResponseWriter, r *http.Request) {
 	authorized, token := Handlers.AuthorizationWrapper(w, r, rh.AuthHandler)
 	if !authorized {
 		return
 	}
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		http.Error(w, "Missing email", http.StatusBadRequest)
+		return
+	}
+	if !rh.resends.claim(token.UID) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	callerId := token.UID
+	time.AfterFunc(resendCallerInterval, func() { rh.resends.release(callerId) })
 
-	friendId := r.URL.Query().Get("friendId")
-	if friendId == "" {
-		http.Error(w, "Missing friendId", http.StatusBadRequest)
+	err := rh.resendRestoreMail(email)
+	if err != nil {
+		log.Printf("Failed to resend restore mail: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("OK"))
+}
 
-	if token.Claims["email_verified"] != true {
-		log.Println("Email not verified")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// claimRestoreMail records on the archived user that a restore mail is sent,
+// unless one was sent within restoreMailInterval, which also holds across
+// restarts and servers.
+func (rh *RestoreHandler) claimRestoreMail(ref *firestore.DocumentRef) (User, bool, error) {
+	var user User
+	claimed := false
+	err := rh.FireStore.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		err = doc.DataTo(&user)
+		if err != nil {
+			return err
+		}
+		if sentAt, ok := doc.Data()["restoreMailSentAt"].(time.Time); ok && time.Since(sentAt) < restoreMailInterval {
+			return nil
+		}
+		claimed = true
+		return tx.Update(ref, []firestore.Update{{Path: "restoreMailSentAt", Value: time.Now()}})
+	})
+	return user, claimed, err
+}
+
+func (rh *RestoreHandler) resendRestoreMail(email string) error {
+	if rh.Mailer == nil {
+		return nil
+	}
+	docs, err := rh.FireStore.Collection("ArchivedUsers").Where("email", "==", email).Documents(context.Background()).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to get archived users: %w", err)
+	}
+	for _, doc := range docs {
+		user, claimed, err := rh.claimRestoreMail(doc.Ref)
+		if err != nil {
+			log.Printf("Failed to claim restore mail of %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		err = sendRestoreMail(rh.Mailer, rh.Tokens, doc.Ref.ID, user)
+		if err != nil {
+			log.Printf("Failed to mail restore token: %v", err)
+		}
+	}
+	return nil
+}
+
+// StatusWrapper returns the restore job of the calling user.
+func (rh *RestoreHandler) StatusWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, rh.AuthHandler)
+	if !authorized {
 		return
 	}
-	oldId, friends := rh.retrieveOldUserData(token.Claims["email"].(string))
-	if oldId == "" {
-		log.Println("No archived user found")
+
+	job, err := rh.getJob(token.UID)
+	if status.Code(err) == codes.NotFound {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to get restore job: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	Handlers.WriteJSON(w, job.toResponse())
+}
 
-	go rh.restoreUserData(token.UID, oldId)
-	go rh.restoreRatings(token.UID, oldId)
-	go rh.restoreUserFriends(token.UID, friends)
+// ServeHTTP restores the archive the token parameter was issued for onto the
+// calling user, or resumes the restore of that archive. It answers 409 while a
+// restore of another archive is still in progress. A GET only checks whether
+// the token can still be used.
+func (rh *RestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	restoreToken := r.URL.Query().Get("token")
+	if r.Method == http.MethodGet {
+		if restoreToken == "" {
+			http.Error(w, "Missing token", http.StatusBadRequest)
+			return
+		}
+		_, code, message := rh.archivedUser(restoreToken)
+		w.WriteHeader(code)
+		_, err := w.Write([]byte(message))
+		if err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+		return
+	}
+
+	authorized, token := Handlers.AuthorizationWrapper(w, r, rh.AuthHandler)
+	if !authorized {
+		return
+	}
+	if restoreToken == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+	oldId, err := rh.Tokens.Verify(restoreToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	//respond with 200 OK
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte("OK"))
+	// A finished job is replaced by a new one, so an account can restore
+	// another archive, or its own again after it was deleted once more
+	job, err := rh.getJob(token.UID)
+	if status.Code(err) == codes.NotFound || (err == nil && job.finished()) {
+		_, code, message := rh.archivedUser(restoreToken)
+		if code != 200 {
+			http.Error(w, message, code)
+			return
+		}
+		job, err = rh.startJob(token.UID, oldId)
+	}
 	if err != nil {
+		log.Printf("Failed to start restore job: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if job.ArchivedUserId != oldId {
+		http.Error(w, "Another restore is still in progress", http.StatusConflict)
+		return
+	}
+
+	// The app follows the job on /restore/status, failed jobs are retried by Run
+	go rh.runJob(job)
+	Handlers.WriteJSONStatus(w, http.StatusAccepted, job.toResponse())
 }
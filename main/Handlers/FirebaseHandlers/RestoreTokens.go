@@ -0,0 +1,95 @@
+package FirebaseHandlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidRestoreToken = errors.New("invalid restore token")
+
+// RestoreTokens signs the tokens that allow restoring an archived account.
+// A token names the archived user and is valid as long as the archive is, so
+// whoever received it by email can restore the account onto any sign-in.
+type RestoreTokens struct {
+	Secret []byte
+}
+
+// NewRestoreTokens uses the given secret, or a random one if it is empty,
+// which invalidates all tokens on restart and is only allowed against the
+// emulator.
+func NewRestoreTokens(secret string) *RestoreTokens {
+	if secret != "" {
+		return &RestoreTokens{Secret: []byte(secret)}
+	}
+	log.Println("No restore secret configured, restore tokens will not survive a restart")
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		log.Fatalf("Failed to create restore secret: %v", err)
+	}
+	return &RestoreTokens{Secret: random}
+}
+
+func (t *RestoreTokens) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, t.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Issue creates the token restoring the archive of userId until expiresAt.
+func (t *RestoreTokens) Issue(userId string, expiresAt time.Time) string {
+	payload := userId + "|" + strconv.FormatInt(expiresAt.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(t.sign(payload))
+}
+
+// Verify returns the archived user the token was issued for.
+func (t *RestoreTokens) Verify(token string) (string, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", errInvalidRestoreToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidRestoreToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, t.sign(string(payload))) {
+		return "", errInvalidRestoreToken
+	}
+	// The expiry is cut off at the last separator, as custom uids may contain one
+	separator := strings.LastIndex(string(payload), "|")
+	if separator < 0 {
+		return "", errInvalidRestoreToken
+	}
+	userId, expiry := string(payload[:separator]), string(payload[separator+1:])
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiresAt, 0)) {
+		return "", errInvalidRestoreToken
+	}
+	return userId, nil
+}
+
+// sendRestoreMail emails a new restore token for the archived user.
+func sendRestoreMail(mailer Mailer, tokens *RestoreTokens, userId string, user User) error {
+	if user.Email == "" {
+		return nil
+	}
+	body := fmt.Sprintf(`Hi %s,
+
+your ScreenSociety account has been deleted. Your profile, friends and ratings are kept until %s.
+
+To get them back, sign in to the app with any account and restore it with this code:
+
+%s
+
+If you do not want to restore your account, you can ignore this mail.`, user.Name, user.ExpiresAt.Format("January 2, 2006"), tokens.Issue(userId, user.ExpiresAt))
+	return mailer.Send(user.Email, "Your ScreenSociety account has been deleted", body)
+}
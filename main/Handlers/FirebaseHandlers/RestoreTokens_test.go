@@ -0,0 +1,45 @@
+package FirebaseHandlers
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreTokenRoundTrip(t *testing.T) {
+	tokens := NewRestoreTokens("secret")
+	token := tokens.Issue("user|with|pipes", time.Now().Add(time.Hour))
+
+	userId, err := tokens.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if userId != "user|with|pipes" {
+		t.Errorf("Verify = %q, want user|with|pipes", userId)
+	}
+}
+
+func TestRestoreTokenRejectsInvalidTokens(t *testing.T) {
+	tokens := NewRestoreTokens("secret")
+	valid := tokens.Issue("user", time.Now().Add(time.Hour))
+	payload, signature, _ := strings.Cut(valid, ".")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := map[string]string{
+		"empty":             "",
+		"no signature":      payload,
+		"invalid payload":   "!!!." + signature,
+		"invalid signature": payload + ".!!!",
+		"tampered payload":  encode([]byte("other|"+strings.Repeat("9", 10))) + "." + signature,
+		"other secret":      NewRestoreTokens("other").Issue("user", time.Now().Add(time.Hour)),
+		"expired":           tokens.Issue("user", time.Now().Add(-time.Second)),
+		"no expiry":         encode([]byte("user")) + "." + encode(tokens.sign("user")),
+		"invalid expiry":    encode([]byte("user|soon")) + "." + encode(tokens.sign("user|soon")),
+	}
+	for name, token := range tests {
+		if userId, err := tokens.Verify(token); err != errInvalidRestoreToken {
+			t.Errorf("%s: Verify = %q, %v, want %v", name, userId, err, errInvalidRestoreToken)
+		}
+	}
+}
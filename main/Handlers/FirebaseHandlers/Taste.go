@@ -0,0 +1,224 @@
+package FirebaseHandlers
+
+import (
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Weights of the signals that make up a predicted score. Content weights
+// apply to the user's own taste, the collaborative weight to friends' ratings.
+const (
+	genreWeight         = 1.0
+	languageWeight      = 0.5
+	termWeight          = 0.3
+	collaborativeWeight = 1.0
+)
+
+var stopWords = map[string]bool{
+	"about": true, "after": true, "against": true, "their": true, "there": true,
+	"these": true, "they": true, "this": true, "when": true, "where": true,
+	"which": true, "while": true, "with": true, "from": true, "into": true,
+	"have": true, "must": true, "that": true, "them": true, "than": true,
+	"what": true, "will": true, "your": true, "been": true, "only": true,
+}
+
+// tasteProfile summarizes what a user likes as the average deviation from
+// their mean rating per genre, original language and overview term.
+type tasteProfile struct {
+	mean      float64
+	ratings   map[string]float64
+	genres    map[string]float64
+	languages map[string]float64
+	terms     map[string]float64
+}
+
+// ratingsByMovie averages the ratings of a user per title, so ratings of
+// single seasons count towards their series.
+func ratingsByMovie(ratings []Rating) map[string]float64 {
+	sums := make(map[string]float64)
+	counts := make(map[string]float64)
+	for _, rating := range ratings {
+		sums[rating.MovieId] += rating.Rating
+		counts[rating.MovieId]++
+	}
+	for movieId := range sums {
+		sums[movieId] /= counts[movieId]
+	}
+	return sums
+}
+
+func overviewTerms(overview string) []string {
+	words := strings.FieldsFunc(strings.ToLower(overview), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if len(word) > 3 && !stopWords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+func average(values map[string]float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+func buildTasteProfile(ratings []Rating, movies map[string]MovieHandlers.MovieResponse) tasteProfile {
+	profile := tasteProfile{
+		ratings:   ratingsByMovie(ratings),
+		genres:    make(map[string]float64),
+		languages: make(map[string]float64),
+		terms:     make(map[string]float64),
+	}
+	profile.mean = average(profile.ratings)
+
+	genreCounts := make(map[string]float64)
+	languageCounts := make(map[string]float64)
+	termCounts := make(map[string]float64)
+	for movieId, rating := range profile.ratings {
+		movie, ok := movies[movieId]
+		if !ok {
+			continue
+		}
+		deviation := rating - profile.mean
+		for _, genre := range movie.Genres {
+			profile.genres[genre.Name] += deviation
+			genreCounts[genre.Name]++
+		}
+		if movie.OriginalLanguage != "" {
+			profile.languages[movie.OriginalLanguage] += deviation
+			languageCounts[movie.OriginalLanguage]++
+		}
+		for _, term := range overviewTerms(movie.Overview) {
+			profile.terms[term] += deviation
+			termCounts[term]++
+		}
+	}
+	for genre := range profile.genres {
+		profile.genres[genre] /= genreCounts[genre]
+	}
+	for language := range profile.languages {
+		profile.languages[language] /= languageCounts[language]
+	}
+	for term := range profile.terms {
+		profile.terms[term] /= termCounts[term]
+	}
+	return profile
+}
+
+// contentScore predicts how much the user deviates from their mean rating
+// for a movie, based on its genres, language and overview.
+func (t tasteProfile) contentScore(movie MovieHandlers.MovieResponse) float64 {
+	score := 0.0
+	if len(movie.Genres) > 0 {
+		genres := 0.0
+		for _, genre := range movie.Genres {
+			genres += t.genres[genre.Name]
+		}
+		score += genreWeight * genres / float64(len(movie.Genres))
+	}
+	score += languageWeight * t.languages[movie.OriginalLanguage]
+	if terms := overviewTerms(movie.Overview); len(terms) > 0 {
+		termScore := 0.0
+		for _, term := range terms {
+			termScore += t.terms[term]
+		}
+		score += termWeight * termScore / float64(len(terms))
+	}
+	return score
+}
+
+// sharedRatings returns the ratings both profiles gave to the same titles.
+func sharedRatings(a, b tasteProfile) ([]string, []float64, []float64) {
+	movieIds := make([]string, 0)
+	aRatings := make([]float64, 0)
+	bRatings := make([]float64, 0)
+	for movieId, rating := range a.ratings {
+		if other, ok := b.ratings[movieId]; ok {
+			movieIds = append(movieIds, movieId)
+			aRatings = append(aRatings, rating)
+			bRatings = append(bRatings, other)
+		}
+	}
+	return movieIds, aRatings, bRatings
+}
+
+// pearson correlates two rating series, returning false if it is undefined.
+func pearson(a, b []float64) (float64, bool) {
+	if len(a) < 2 {
+		return 0, false
+	}
+	meanA, meanB := 0.0, 0.0
+	for index := range a {
+		meanA += a[index]
+		meanB += b[index]
+	}
+	meanA /= float64(len(a))
+	meanB /= float64(len(b))
+
+	covariance, varianceA, varianceB := 0.0, 0.0, 0.0
+	for index := range a {
+		covariance += (a[index] - meanA) * (b[index] - meanB)
+		varianceA += (a[index] - meanA) * (a[index] - meanA)
+		varianceB += (b[index] - meanB) * (b[index] - meanB)
+	}
+	if varianceA == 0 || varianceB == 0 {
+		return 0, false
+	}
+	return covariance / math.Sqrt(varianceA*varianceB), true
+}
+
+// similarity weighs how much a friend's ratings say about the user's taste.
+// Friends without enough shared ratings still count a little.
+func similarity(user, friend tasteProfile) float64 {
+	_, userRatings, friendRatings := sharedRatings(user, friend)
+	correlation, ok := pearson(userRatings, friendRatings)
+	if !ok {
+		return 0.1
+	}
+	return correlation
+}
+
+// collaborativeScore predicts the user's deviation from their mean rating
+// from the deviations of similar friends who rated the movie.
+func collaborativeScore(movieId string, friends []tasteProfile, similarities []float64) (float64, bool) {
+	weighted, weights := 0.0, 0.0
+	for index, friend := range friends {
+		rating, ok := friend.ratings[movieId]
+		if !ok || similarities[index] == 0 {
+			continue
+		}
+		weighted += similarities[index] * (rating - friend.mean)
+		weights += math.Abs(similarities[index])
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return weighted / weights, true
+}
+
+// cosine compares two weight vectors, returning false if either is empty.
+func cosine(a, b map[string]float64) (float64, bool) {
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for key, value := range a {
+		dot += value * b[key]
+		normA += value * value
+	}
+	for _, value := range b {
+		normB += value * value
+	}
+	if normA == 0 || normB == 0 {
+		return 0, false
+	}
+	return dot / math.Sqrt(normA*normB), true
+}
@@ -0,0 +1,180 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultRecommendations = 20
+	maxRecommendations     = 100
+	// firestoreInLimit is the maximum number of values of an "in" query.
+	firestoreInLimit = 10
+)
+
+// TasteHandler serves the features built on users' rating histories.
+type TasteHandler struct {
+	AuthHandler   *auth.Client
+	FireStore     *firestore.Client
+	MongoHandler  *MovieHandlers.MongoHandler
+	FriendHandler *FriendHandler
+	FcmHandler    *FcmHandler
+}
+
+type recommendation struct {
+	MovieId string                      `json:"movieId"`
+	Score   float64                     `json:"score"`
+	Movie   MovieHandlers.MovieResponse `json:"movie"`
+}
+
+func (t *TasteHandler) getUser(userId string) (User, error) {
+	doc, err := t.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	err = doc.DataTo(&user)
+	return user, err
+}
+
+// fetchRatings loads the ratings of all given users, keyed by user id.
+func (t *TasteHandler) fetchRatings(userIds []string) (map[string][]Rating, error) {
+	ratings := make(map[string][]Rating, len(userIds))
+	for start := 0; start < len(userIds); start += firestoreInLimit {
+		end := start + firestoreInLimit
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+		docs, err := t.FireStore.Collection("Ratings").Where("userId", "in", userIds[start:end]).Documents(context.Background()).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var rating Rating
+			err = doc.DataTo(&rating)
+			if err != nil {
+				log.Printf("Failed to convert data: %v", err)
+				continue
+			}
+			ratings[rating.UserId] = append(ratings[rating.UserId], rating)
+		}
+	}
+	return ratings, nil
+}
+
+func (t *TasteHandler) fetchMovies() (map[string]MovieHandlers.MovieResponse, error) {
+	cached, err := t.MongoHandler.FetchAllFromCache()
+	if err != nil {
+		return nil, err
+	}
+	movies := make(map[string]MovieHandlers.MovieResponse, len(cached))
+	for _, movie := range cached {
+		movies[movie.IMDBID] = movie
+	}
+	return movies, nil
+}
+
+// recommend scores every cached title the user has not rated yet. An empty
+// country skips the streaming filter.
+func (t *TasteHandler) recommend(userId, country string, limit int) ([]recommendation, int, string) {
+	user, err := t.getUser(userId)
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return nil, 404, "user doesn't exist"
+	}
+	ratings, err := t.fetchRatings(append([]string{userId}, user.Friends...))
+	if err != nil {
+		log.Printf("Failed to get ratings: %v", err)
+		return nil, 500, "Internal Server Error"
+	}
+	movies, err := t.fetchMovies()
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		return nil, 500, "Internal Server Error"
+	}
+
+	profile := buildTasteProfile(ratings[userId], movies)
+	friends := make([]tasteProfile, 0, len(user.Friends))
+	similarities := make([]float64, 0, len(user.Friends))
+	for _, friendId := range user.Friends {
+		friend := buildTasteProfile(ratings[friendId], movies)
+		friends = append(friends, friend)
+		similarities = append(similarities, similarity(profile, friend))
+	}
+
+	recommendations := make([]recommendation, 0)
+	for movieId, movie := range movies {
+		if _, rated := profile.ratings[movieId]; rated {
+			continue
+		}
+		if country != "" && !movie.StreamableIn(country) {
+			continue
+		}
+		score := profile.contentScore(movie)
+		if collaborative, ok := collaborativeScore(movieId, friends, similarities); ok {
+			score += collaborativeWeight * collaborative
+		}
+		recommendations = append(recommendations, recommendation{MovieId: movieId, Score: score, Movie: movie})
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score == recommendations[j].Score {
+			return recommendations[i].Movie.TMDBRating > recommendations[j].Movie.TMDBRating
+		}
+		return recommendations[i].Score > recommendations[j].Score
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+
+	responseCountry := country
+	if responseCountry == "" {
+		responseCountry = userCountry(user)
+	}
+	for index := range recommendations {
+		recommendations[index].Movie = recommendations[index].Movie.ForCountry(responseCountry)
+	}
+	return recommendations, 200, "Ok"
+}
+
+func parseLimit(r *http.Request, defaultLimit, maxLimit int) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
+// RecommendationsWrapper recommends unseen titles from the user's and their
+// friends' ratings, only including titles streamable in the country parameter if given.
+func (t *TasteHandler) RecommendationsWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, t.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	limit, ok := parseLimit(r, defaultRecommendations, maxRecommendations)
+	if !ok {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	country := strings.ToLower(r.URL.Query().Get("country"))
+
+	recommendations, code, message := t.recommend(token.UID, country, limit)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, recommendations)
+}
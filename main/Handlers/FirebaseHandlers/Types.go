@@ -3,8 +3,11 @@ package FirebaseHandlers
 import "time"
 
 type Rating struct {
-	UserId    string    `firestore:"userId"`
-	MovieId   string    `firestore:"movieId"`
+	UserId  string `firestore:"userId"`
+	MovieId string `firestore:"movieId"`
+	// Season optionally narrows the rating of a series down to one 1-based
+	// season, like /inspect does, 0 rates the whole title.
+	Season    int       `firestore:"season,omitempty"`
 	Rating    float64   `firestore:"rating"`
 	Comment   string    `firestore:"comment"`
 	Timestamp time.Time `firestore:"timestamp"`
@@ -12,13 +15,48 @@ type Rating struct {
 }
 
 type User struct {
-	Email            string    `firestore:"email"`
-	Friends          []string  `firestore:"friends,omitempty"`
-	Name             string    `firestore:"name"`
-	Picture          string    `firestore:"picture"`
-	RatedMovies      []string  `firestore:"ratedMovies,omitempty"`
-	FriendRequests   []string  `firestore:"friendRequests,omitempty"`
-	OutgoingRequests []string  `firestore:"outgoingRequests,omitempty"`
-	ExpiresAt        time.Time `firestore:"expiresAt,omitempty"`
-	FcmToken         string    `firestore:"fcmToken,omitempty"`
+	Email             string             `firestore:"email"`
+	Friends           []string           `firestore:"friends,omitempty"`
+	Name              string             `firestore:"name"`
+	Picture           string             `firestore:"picture"`
+	RatedMovies       []string           `firestore:"ratedMovies,omitempty"`
+	Watchlist         []string           `firestore:"watchlist,omitempty"`
+	FriendRequests    []string           `firestore:"friendRequests,omitempty"`
+	OutgoingRequests  []string           `firestore:"outgoingRequests,omitempty"`
+	ExpiresAt         time.Time          `firestore:"expiresAt,omitempty"`
+	FcmToken          string             `firestore:"fcmToken,omitempty"`
+	Country           string             `firestore:"country,omitempty"`
+	StreamingServices []string           `firestore:"streamingServices,omitempty"`
+	FollowedTitles    []string           `firestore:"followedTitles,omitempty"`
+	PriceAlerts       map[string]float64 `firestore:"priceAlerts,omitempty"`
+	// NotifiedTitles holds the services a followed title was streamable on when the user was last notified.
+	NotifiedTitles map[string][]string `firestore:"notifiedTitles,omitempty"`
+	// AlertedPrices holds the price a price alert was last sent for.
+	AlertedPrices map[string]float64 `firestore:"alertedPrices,omitempty"`
+}
+
+type Poll struct {
+	CreatorId        string            `firestore:"creatorId"`
+	Title            string            `firestore:"title"`
+	Candidates       []string          `firestore:"candidates"`
+	Invitees         []string          `firestore:"invitees"`
+	Votes            map[string]string `firestore:"votes"`
+	Deadline         time.Time         `firestore:"deadline"`
+	Closed           bool              `firestore:"closed"`
+	Winner           string            `firestore:"winner,omitempty"`
+	AllVotedNotified bool              `firestore:"allVotedNotified"`
+	CreatedAt        time.Time         `firestore:"createdAt"`
+}
+
+type Reply struct {
+	UserId    string    `firestore:"userId"`
+	Text      string    `firestore:"text"`
+	Timestamp time.Time `firestore:"timestamp"`
+	EditedAt  time.Time `firestore:"editedAt,omitempty"`
+}
+
+type Reaction struct {
+	UserId    string    `firestore:"userId"`
+	Emoji     string    `firestore:"emoji"`
+	Timestamp time.Time `firestore:"timestamp"`
 }
@@ -0,0 +1,118 @@
+package FirebaseHandlers
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"github.com/ItzBubschki/mr-backend/main/Handlers/MovieHandlers"
+	"log"
+	"net/http"
+)
+
+type WatchlistHandler struct {
+	AuthHandler  *auth.Client
+	FireStore    *firestore.Client
+	MongoHandler *MovieHandlers.MongoHandler
+}
+
+type watchlistEntry struct {
+	MovieId string                       `json:"movieId"`
+	Movie   *MovieHandlers.MovieResponse `json:"movie,omitempty"`
+}
+
+func userCountry(user User) string {
+	if user.Country == "" {
+		return MovieHandlers.DefaultCountry
+	}
+	return user.Country
+}
+
+func (wh *WatchlistHandler) updateWatchlist(userId string, value interface{}) (int, string) {
+	_, err := wh.FireStore.Collection("Users").Doc(userId).Update(context.Background(), []firestore.Update{{Path: "watchlist", Value: value}})
+	if err != nil {
+		log.Printf("Failed to update user: %v", err)
+		return 500, "Internal Server Error"
+	}
+	return 200, "Ok"
+}
+
+// listWatchlist returns the watchlist in the order it was added, with the
+// movies that are in the cache filled in.
+func (wh *WatchlistHandler) listWatchlist(userId string) ([]watchlistEntry, int, string) {
+	userDoc, err := wh.FireStore.Collection("Users").Doc(userId).Get(context.Background())
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return nil, 404, "user doesn't exist"
+	}
+	var user User
+	err = userDoc.DataTo(&user)
+	if err != nil {
+		log.Printf("Failed to convert data: %v", err)
+		return nil, 500, "Internal Server Error"
+	}
+
+	movies, err := wh.MongoHandler.FetchManyFromCache(user.Watchlist)
+	if err != nil {
+		log.Printf("Failed to fetch movies: %v", err)
+		movies = map[string]MovieHandlers.MovieResponse{}
+	}
+	entries := make([]watchlistEntry, 0, len(user.Watchlist))
+	for _, movieId := range user.Watchlist {
+		entry := watchlistEntry{MovieId: movieId}
+		if movie, ok := movies[movieId]; ok {
+			movie = movie.WithoutSeasons().ForCountry(userCountry(user))
+			entry.Movie = &movie
+		}
+		entries = append(entries, entry)
+	}
+	return entries, 200, "Ok"
+}
+
+func (wh *WatchlistHandler) AddWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, wh.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+
+	code, message := wh.updateWatchlist(token.UID, firestore.ArrayUnion(movieId))
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (wh *WatchlistHandler) RemoveWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, wh.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "No movieId provided", http.StatusBadRequest)
+		return
+	}
+
+	code, message := wh.updateWatchlist(token.UID, firestore.ArrayRemove(movieId))
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(message))
+}
+
+func (wh *WatchlistHandler) ListWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, token := Handlers.AuthorizationWrapper(w, r, wh.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	entries, code, message := wh.listWatchlist(token.UID)
+	if code != 200 {
+		http.Error(w, message, code)
+		return
+	}
+	Handlers.WriteJSON(w, entries)
+}
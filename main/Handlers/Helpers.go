@@ -2,6 +2,7 @@ package Handlers
 
 import (
 	"context"
+	"encoding/json"
 	"firebase.google.com/go/v4/auth"
 	"log"
 	"net/http"
@@ -39,3 +40,41 @@ func AuthorizationWrapper(w http.ResponseWriter, r *http.Request, authHandler *a
 
 	return true, token
 }
+
+// AdminWrapper authorizes the request like AuthorizationWrapper and
+// additionally requires the admin custom claim.
+func AdminWrapper(w http.ResponseWriter, r *http.Request, authHandler *auth.Client) (bool, *auth.Token) {
+	authorized, token := AuthorizationWrapper(w, r, authHandler)
+	if !authorized {
+		return false, nil
+	}
+	if token.Claims["admin"] != true {
+		log.Printf("User %s is not an admin", token.UID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false, nil
+	}
+	return true, token
+}
+
+func WriteJSON(w http.ResponseWriter, value interface{}) {
+	WriteJSONStatus(w, http.StatusOK, value)
+}
+
+// WriteJSONStatus writes the value as JSON with the given status code.
+func WriteJSONStatus(w http.ResponseWriter, code int, value interface{}) {
+	// Convert response to JSON
+	jsonResponse, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Failed to marshal JSON response:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Set response headers and write JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(jsonResponse)
+	if err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package MovieHandlers
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// CacheRefresher periodically re-inspects stale cache entries without spending
+// more than DailyBudget provider calls per day.
+type CacheRefresher struct {
+	Mongo       *MongoHandler
+	Provider    MovieProvider
+	DailyBudget int
+	Interval    time.Duration
+	// Priority optionally returns the movies users wait on, like followed
+	// titles, which are refreshed before all others.
+	Priority func() []string
+	day      string
+	used     int
+}
+
+func (c *CacheRefresher) Run() {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.refresh()
+		<-ticker.C
+	}
+}
+
+// remainingBudget spreads the daily budget evenly over the ticks of a day.
+func (c *CacheRefresher) remainingBudget() int {
+	today := time.Now().Format("2006-01-02")
+	if c.day != today {
+		c.day = today
+		c.used = 0
+	}
+	perTick := int(int64(c.DailyBudget) * int64(c.Interval) / int64(24*time.Hour))
+	if perTick < 1 {
+		perTick = 1
+	}
+	remaining := c.DailyBudget - c.used
+	if remaining > perTick {
+		return perTick
+	}
+	return remaining
+}
+
+// fetchStale returns up to budget stale movies, the prioritized ones first.
+func (c *CacheRefresher) fetchStale(budget int) ([]MovieResponse, error) {
+	stale := make([]MovieResponse, 0, budget)
+	if c.Priority != nil {
+		if movieIds := c.Priority(); len(movieIds) > 0 {
+			prioritized, err := c.Mongo.FetchStale(budget, movieIds)
+			if err != nil {
+				return nil, err
+			}
+			stale = append(stale, prioritized...)
+		}
+	}
+	if len(stale) >= budget {
+		return stale, nil
+	}
+	rest, err := c.Mongo.FetchStale(budget, nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(stale))
+	for _, movie := range stale {
+		seen[movie.IMDBID] = true
+	}
+	for _, movie := range rest {
+		if len(stale) == budget {
+			break
+		}
+		if !seen[movie.IMDBID] {
+			stale = append(stale, movie)
+		}
+	}
+	return stale, nil
+}
+
+func (c *CacheRefresher) refresh() {
+	budget := c.remainingBudget()
+	if budget <= 0 {
+		return
+	}
+	stale, err := c.fetchStale(budget)
+	if err != nil {
+		log.Println("Failed to fetch stale movies:", err)
+		return
+	}
+
+	refreshed := make([]MovieResponse, 0, len(stale))
+	for _, cached := range stale {
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamDeadline)
+		movie, err := c.Provider.Inspect(ctx, cached.IMDBID)
+		cancel()
+		if err == ErrQuotaExceeded || err == ErrCircuitOpen {
+			log.Println("Provider unavailable, pausing refresh:", err)
+			break
+		}
+		c.used++
+		if err != nil {
+			log.Printf("Failed to refresh %s: %v", cached.IMDBID, err)
+			c.Mongo.MarkRefreshFailed(cached.IMDBID)
+			continue
+		}
+		refreshed = append(refreshed, movie)
+	}
+	c.Mongo.SaveInCache(refreshed)
+	log.Printf("Refreshed %d of %d stale movies", len(refreshed), len(stale))
+}
@@ -0,0 +1,27 @@
+package MovieHandlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+const DefaultCountry = "de"
+
+// CountryResolver looks up the preferred streaming country of the user making
+// a request, returning an empty string if it is unknown.
+type CountryResolver interface {
+	CountryForRequest(r *http.Request) string
+}
+
+// requestCountry picks the country from the query, then the user's profile,
+// then the default.
+func requestCountry(r *http.Request, resolver CountryResolver) string {
+	country := strings.ToLower(r.URL.Query().Get("country"))
+	if country == "" && resolver != nil {
+		country = strings.ToLower(resolver.CountryForRequest(r))
+	}
+	if country == "" {
+		country = DefaultCountry
+	}
+	return country
+}
@@ -0,0 +1,70 @@
+package MovieHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+)
+
+// FileProvider serves movies from a fixture file containing one MovieResponse
+// per JSON document, e.g. a mongoexport of the cache like db-backup.json.
+type FileProvider struct {
+	movies []MovieResponse
+	byId   map[string]int
+}
+
+func NewFileProvider(path string) (*FileProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			return
+		}
+	}(file)
+
+	provider := &FileProvider{byId: make(map[string]int)}
+	decoder := json.NewDecoder(file)
+	for {
+		var movie MovieResponse
+		err := decoder.Decode(&movie)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if movie.IMDBID == "" {
+			continue
+		}
+		provider.byId[movie.IMDBID] = len(provider.movies)
+		provider.movies = append(provider.movies, movie)
+	}
+	return provider, nil
+}
+
+func (p *FileProvider) Search(_ context.Context, term, _ string) ([]MovieResponse, error) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	movies := make([]MovieResponse, 0)
+	if term == "" {
+		return movies, nil
+	}
+	for _, movie := range p.movies {
+		if strings.Contains(strings.ToLower(movie.Title), term) || strings.Contains(strings.ToLower(movie.OriginalTitle), term) {
+			movies = append(movies, movie)
+		}
+	}
+	return movies, nil
+}
+
+func (p *FileProvider) Inspect(_ context.Context, movieId string) (MovieResponse, error) {
+	index, ok := p.byId[movieId]
+	if !ok {
+		return MovieResponse{}, ErrMovieNotFound
+	}
+	return p.movies[index], nil
+}
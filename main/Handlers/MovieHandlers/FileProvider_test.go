@@ -0,0 +1,67 @@
+package MovieHandlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	return path
+}
+
+func TestFileProvider(t *testing.T) {
+	path := writeFixture(t, `{"_id":{"$oid":"1"},"title":"","imdbid":""}
+{"_id":{"$oid":"2"},"type":"movie","title":"Pulp Fiction","originaltitle":"Pulp Fiction","imdbid":"tt0110912","streaminginfo":{"de":{"netflix":[{"type":"subscription"}]}}}
+{"_id":{"$oid":"3"},"type":"movie","title":"Die fabelhafte Welt der Amelie","originaltitle":"Le Fabuleux Destin d'Amélie Poulain","imdbid":"tt0211915"}
+`)
+	provider, err := NewFileProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileProvider failed: %v", err)
+	}
+
+	movie, err := provider.Inspect(context.Background(), "tt0110912")
+	if err != nil || movie.Title != "Pulp Fiction" {
+		t.Errorf("Inspect = %q, %v, want Pulp Fiction", movie.Title, err)
+	}
+	if !movie.StreamableOn("de", "netflix") {
+		t.Error("Inspect lost the streaming info of the fixture")
+	}
+	if _, err = provider.Inspect(context.Background(), "tt0000000"); err != ErrMovieNotFound {
+		t.Errorf("Inspect of an unknown id = %v, want %v", err, ErrMovieNotFound)
+	}
+
+	tests := map[string]int{"pulp": 1, " AMÉLIE ": 1, "i": 2, "": 0, "matrix": 0}
+	for term, want := range tests {
+		movies, err := provider.Search(context.Background(), term, "")
+		if err != nil || len(movies) != want {
+			t.Errorf("Search(%q) = %d movies, %v, want %d", term, len(movies), err, want)
+		}
+	}
+}
+
+func TestFileProviderRejectsInvalidFixtures(t *testing.T) {
+	if _, err := NewFileProvider(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("NewFileProvider succeeded without a fixture file")
+	}
+	if _, err := NewFileProvider(writeFixture(t, `{"title":`)); err == nil {
+		t.Error("NewFileProvider succeeded with a broken fixture file")
+	}
+}
+
+func TestFileProviderReadsBackup(t *testing.T) {
+	provider, err := NewFileProvider("../../../db-backup.json")
+	if err != nil {
+		t.Fatalf("NewFileProvider failed on the cache backup: %v", err)
+	}
+	movies, err := provider.Search(context.Background(), "Pulp Fiction", "")
+	if err != nil || len(movies) == 0 {
+		t.Errorf("Search found %d movies in the cache backup, %v", len(movies), err)
+	}
+}
@@ -0,0 +1,134 @@
+package MovieHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// batchInspectLimit caps the ids a single batch request may ask for.
+	batchInspectLimit = 100
+	// batchInspectWorkers bounds the concurrent provider calls of a batch.
+	batchInspectWorkers = 5
+	// batchFetchLimit caps the cache misses a single batch request fetches from
+	// the provider, the remaining misses are answered as pending.
+	batchFetchLimit = 10
+)
+
+type batchInspectRequest struct {
+	MovieIds []string `json:"movieIds"`
+}
+
+type batchInspectItem struct {
+	Movie *MovieResponse `json:"movie,omitempty"`
+	Error string         `json:"error,omitempty"`
+	// Pending is set for misses that were not fetched yet, they can be asked for again.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// fetchMissing inspects the given ids with a bounded pool of workers.
+func (i *InspectHandler) fetchMissing(ctx context.Context, movieIds []string) map[string]batchInspectItem {
+	results := make(map[string]batchInspectItem, len(movieIds))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	ids := make(chan string)
+
+	for worker := 0; worker < batchInspectWorkers && worker < len(movieIds); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for movieId := range ids {
+				var item batchInspectItem
+				movie, err := i.searchForSingleMovie(ctx, movieId)
+				if err == ErrMovieNotFound {
+					item.Error = "Not found"
+				} else if err != nil {
+					log.Printf("Failed to fetch %s from provider: %v", movieId, err)
+					item.Error = "Failed to fetch movie"
+				} else {
+					item.Movie = &movie
+				}
+				mutex.Lock()
+				results[movieId] = item
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, movieId := range movieIds {
+		ids <- movieId
+	}
+	close(ids)
+	wg.Wait()
+	return results
+}
+
+// BatchWrapper resolves a list of movies at once, answering cache hits with a
+// single query and fetching up to batchFetchLimit misses from the provider.
+func (i *InspectHandler) BatchWrapper(w http.ResponseWriter, r *http.Request) {
+	authorized, _ := Handlers.AuthorizationWrapper(w, r, i.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	var request batchInspectRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.MovieIds) == 0 {
+		http.Error(w, "Movie IDs are required", http.StatusBadRequest)
+		return
+	}
+	if len(request.MovieIds) > batchInspectLimit {
+		http.Error(w, "Too many movie IDs", http.StatusBadRequest)
+		return
+	}
+
+	movieIds := make([]string, 0, len(request.MovieIds))
+	seen := make(map[string]bool)
+	for _, movieId := range request.MovieIds {
+		if movieId != "" && !seen[movieId] {
+			seen[movieId] = true
+			movieIds = append(movieIds, movieId)
+		}
+	}
+
+	cached, err := i.Mongo.FetchManyFromCache(movieIds)
+	if err != nil {
+		log.Println("Failed to fetch movies from cache:", err)
+		cached = map[string]MovieResponse{}
+	}
+	missing := make([]string, 0)
+	for _, movieId := range movieIds {
+		if movie, ok := cached[movieId]; !ok || len(movie.Title) == 0 {
+			missing = append(missing, movieId)
+		}
+	}
+	log.Printf("Found %d of %d movies in cache", len(movieIds)-len(missing), len(movieIds))
+
+	var pending []string
+	if len(missing) > batchFetchLimit {
+		missing, pending = missing[:batchFetchLimit], missing[batchFetchLimit:]
+	}
+	results := i.fetchMissing(r.Context(), missing)
+	for _, movieId := range pending {
+		results[movieId] = batchInspectItem{Pending: true}
+	}
+	for movieId, movie := range cached {
+		if len(movie.Title) > 0 {
+			movie := movie
+			results[movieId] = batchInspectItem{Movie: &movie}
+		}
+	}
+
+	country := requestCountry(r, i.Countries)
+	for movieId, item := range results {
+		if item.Movie != nil {
+			movie := item.Movie.WithoutSeasons().ForCountry(country)
+			results[movieId] = batchInspectItem{Movie: &movie}
+		}
+	}
+
+	Handlers.WriteJSON(w, results)
+}
@@ -1,52 +1,50 @@
 package MovieHandlers
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
+	"firebase.google.com/go/v4/auth"
 	"github.com/ItzBubschki/mr-backend/main/Handlers"
-	"io"
+	"golang.org/x/sync/singleflight"
 	"log"
 	"net/http"
+	"strconv"
 )
 
-type externalInspectMovieResponse struct {
-	Result MovieResponse `json:"result"`
-}
-
 type InspectHandler struct {
-	Mongo *MongoHandler
+	// AuthHandler authorizes batch requests, which may cause several provider calls
+	AuthHandler *auth.Client
+	Mongo       *MongoHandler
+	Provider    MovieProvider
+	Countries   CountryResolver
+	// inFlight lets concurrent cache misses for the same movie share one provider call
+	inFlight singleflight.Group
 }
 
-func (i *InspectHandler) searchForSingleMovie(movieId string) (MovieResponse, error) {
-	url := Handlers.InspectUrl + movieId
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("x-rapidapi-key", Handlers.ApiKey)
-	req.Header.Add("x-rapidapi-host", Handlers.ApiHost)
-
-	res, _ := http.DefaultClient.Do(req)
-	
-	if res.StatusCode != 200 {
-		return MovieResponse{}, errors.New("received non-200 status code")
-	}
-
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+func (i *InspectHandler) searchForSingleMovie(ctx context.Context, movieId string) (MovieResponse, error) {
+	results := i.inFlight.DoChan(movieId, func() (interface{}, error) {
+		// The call is shared with other requests, so it must not end with this one
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamDeadline)
+		defer cancel()
+		movie, err := i.Provider.Inspect(ctx, movieId)
 		if err != nil {
-			return
+			return MovieResponse{}, err
 		}
-	}(res.Body)
-	body, _ := io.ReadAll(res.Body)
+		movie.summarizeSeasons()
 
-	var movieResponse externalInspectMovieResponse
-	log.Println("Got movie from api")
-	err := json.Unmarshal(body, &movieResponse)
-	if err != nil {
-		return MovieResponse{}, err
-	}
+		go i.Mongo.SaveInCache([]MovieResponse{movie})
 
-	go i.Mongo.SaveInCache([]MovieResponse{movieResponse.Result})
+		return movie, nil
+	})
 
-	return movieResponse.Result, nil
+	select {
+	case <-ctx.Done():
+		return MovieResponse{}, ctx.Err()
+	case result := <-results:
+		if result.Shared {
+			log.Println("Shared provider call for", movieId)
+		}
+		return result.Val.(MovieResponse), result.Err
+	}
 }
 
 func (i *InspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -56,34 +54,55 @@ func (i *InspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Seasons are 1-based like in ratings, 0 means no season was requested
+	seasonNumber := 0
+	if season := r.URL.Query().Get("season"); season != "" {
+		number, err := strconv.Atoi(season)
+		if err != nil || number < 1 {
+			http.Error(w, "Invalid season", http.StatusBadRequest)
+			return
+		}
+		seasonNumber = number
+	}
+	withSeasons := seasonNumber > 0 || r.URL.Query().Get("seasons") == "true"
+
 	var movie MovieResponse
 	movie, err := i.Mongo.FetchFromCache(movieId)
-	if len(movie.Title) == 0 {
+	missingSeasons := withSeasons && movie.Type == "series" && len(movie.Seasons) == 0
+	if len(movie.Title) == 0 || missingSeasons {
 		if err != nil {
 			log.Println("Failed to fetch movie from cache:", err)
 		}
-		movie, err = i.searchForSingleMovie(movieId)
+		fetched, err := i.searchForSingleMovie(r.Context(), movieId)
 		if err != nil {
-			http.Error(w, "Not found", http.StatusNotFound)
-			return
+			log.Println("Failed to fetch movie from provider:", err)
+			if err == ErrQuotaExceeded {
+				w.Header().Set("X-Degraded-Mode", "true")
+			}
+			if !missingSeasons {
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+		} else {
+			movie = fetched
 		}
+	} else if i.Mongo.IsStale(movie) {
+		// Refreshing is left to the CacheRefresher, which keeps to the daily budget
+		log.Println("Found stale movie in cache")
 	} else {
 		log.Println("Found movie in cache")
 	}
 
-	// Convert response to JSON
-	jsonResponse, err := json.Marshal(movie)
-	if err != nil {
-		log.Println("Failed to marshal JSON response:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if seasonNumber > 0 {
+		var ok bool
+		movie, ok = movie.Season(seasonNumber)
+		if !ok {
+			http.Error(w, "Season not found", http.StatusNotFound)
+			return
+		}
+	} else if !withSeasons {
+		movie = movie.WithoutSeasons()
 	}
 
-	// Set response headers and write JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(jsonResponse)
-	if err != nil {
-		return
-	}
+	Handlers.WriteJSON(w, movie.ForCountry(requestCountry(r, i.Countries)))
 }
@@ -7,11 +7,21 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"time"
 )
 
 type MongoHandler struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	searches   *mongo.Collection
+	prices     *mongo.Collection
+	// StreamingTTL is how long cached streaming links are considered fresh, 0
+	// if the provider supplies no streaming info and the links never go stale.
+	StreamingTTL time.Duration
+	// MetadataTTL is how long the remaining movie metadata is considered fresh.
+	MetadataTTL time.Duration
+	// SearchTTL is how long a cached search result is served before asking the provider again.
+	SearchTTL time.Duration
 }
 
 func NewMongoHandler(mongoHost string) (*MongoHandler, error) {
@@ -24,17 +34,100 @@ func NewMongoHandler(mongoHost string) (*MongoHandler, error) {
 		return nil, err
 	}
 
-	// Set up collection
+	// Set up collections
 	collection := client.Database("mr-cache").Collection("movies")
+	searches := client.Database("mr-cache").Collection("searches")
+	prices := client.Database("mr-cache").Collection("prices")
 
 	// Create and return MongoHandler instance
 	handler := &MongoHandler{
 		client:     client,
 		collection: collection,
+		searches:   searches,
+		prices:     prices,
 	}
+	go handler.ensureIndexes()
 	return handler, nil
 }
 
+func (m *MongoHandler) ensureIndexes() {
+	m.ensurePriceCollection()
+
+	_, err := m.searches.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "query", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresat", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		log.Println("Failed to create search cache indexes:", err)
+	}
+
+	_, err = m.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "title", Value: "text"},
+			{Key: "originaltitle", Value: "text"},
+			{Key: "tagline", Value: "text"},
+			{Key: "overview", Value: "text"},
+		},
+		Options: options.Index().SetName("movie_text").SetWeights(bson.D{
+			{Key: "title", Value: 10},
+			{Key: "originaltitle", Value: 10},
+			{Key: "tagline", Value: 2},
+			{Key: "overview", Value: 1},
+		}),
+	})
+	if err != nil {
+		log.Println("Failed to create movie text index:", err)
+	}
+
+	m.ensureImdbIndex()
+}
+
+func (m *MongoHandler) ensureImdbIndex() {
+	_, err := m.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "imdbid", Value: 1}},
+		Options: options.Index().SetName("imdbid_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"imdbid": bson.M{"$gt": ""}}),
+	})
+	if err != nil {
+		log.Println("Failed to create imdbid index, run once with -removeDuplicates if the cache holds duplicates:", err)
+	}
+}
+
+// RemoveDuplicates is a one-off migration for caches written by older versions
+// of SaveInCache, which could insert a movie more than once. Per imdbId it
+// keeps the document with the most fields, then the most recently fetched
+// one, and creates the unique imdbid index afterwards.
+func (m *MongoHandler) RemoveDuplicates() {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"imdbid": bson.M{"$gt": ""}}}},
+		{{Key: "$addFields", Value: bson.M{"fieldcount": bson.M{"$size": bson.M{"$objectToArray": "$$ROOT"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "fieldcount", Value: -1}, {Key: "fetchedat", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$imdbid", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := m.collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		log.Println("Failed to find duplicate movies:", err)
+		return
+	}
+
+	var duplicates []struct {
+		Ids []interface{} `bson:"ids"`
+	}
+	if err := cursor.All(context.Background(), &duplicates); err != nil {
+		log.Println("Failed to decode duplicate movies:", err)
+		return
+	}
+	for _, duplicate := range duplicates {
+		_, err := m.collection.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}})
+		if err != nil {
+			log.Println("Failed to remove duplicate movies:", err)
+		}
+	}
+	log.Printf("Removed duplicates of %d movies", len(duplicates))
+	m.ensureImdbIndex()
+}
+
 func (m *MongoHandler) FetchFromCache(movieId string) (MovieResponse, error) {
 	filter := bson.M{"imdbid": movieId}
 	result := m.collection.FindOne(context.Background(), filter)
@@ -53,21 +146,186 @@ func (m *MongoHandler) FetchFromCache(movieId string) (MovieResponse, error) {
 	return movie, nil
 }
 
+// FetchManyFromCache returns the cached movies for the given ids, keyed by imdbId.
+func (m *MongoHandler) FetchManyFromCache(movieIds []string) (map[string]MovieResponse, error) {
+	movies := make(map[string]MovieResponse, len(movieIds))
+	if len(movieIds) == 0 {
+		return movies, nil
+	}
+	cursor, err := m.collection.Find(context.Background(), bson.M{"imdbid": bson.M{"$in": movieIds}})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MovieResponse
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	for _, movie := range results {
+		movies[movie.IMDBID] = movie
+	}
+	return movies, nil
+}
+
+// SearchInCache runs a full-text search over the cached movies, best matches first.
+func (m *MongoHandler) SearchInCache(term string, limit int) ([]MovieResponse, error) {
+	filter := bson.M{"$text": bson.M{"$search": term}}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetLimit(int64(limit))
+	cursor, err := m.collection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	movies := make([]MovieResponse, 0)
+	if err := cursor.All(context.Background(), &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// FetchAllFromCache returns every cached movie without season details.
+func (m *MongoHandler) FetchAllFromCache() ([]MovieResponse, error) {
+	filter := bson.M{"imdbid": bson.M{"$gt": ""}}
+	cursor, err := m.collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"seasons": 0}))
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []MovieResponse
+	if err := cursor.All(context.Background(), &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// IsStale reports whether a cached movie should be fetched from the provider again.
+func (m *MongoHandler) IsStale(movie MovieResponse) bool {
+	if time.Since(movie.FetchedAt) > m.MetadataTTL {
+		return true
+	}
+	return m.StreamingTTL > 0 && time.Since(movie.StreamingFetchedAt) > m.StreamingTTL
+}
+
+// refreshRetryDelay is how long a movie whose refresh failed is left alone, so
+// failing movies don't use up the budget of every refresh.
+const refreshRetryDelay = 24 * time.Hour
+
+// FetchStale returns up to limit cached movies that need a refresh, oldest
+// first, leaving out movies whose refresh failed within refreshRetryDelay.
+// If movieIds is not nil, only those movies are looked at.
+func (m *MongoHandler) FetchStale(limit int, movieIds []string) ([]MovieResponse, error) {
+	now := time.Now()
+	stale := bson.A{
+		bson.M{"fetchedat": bson.M{"$exists": false}},
+		bson.M{"fetchedat": bson.M{"$lt": now.Add(-m.MetadataTTL)}},
+	}
+	sort := bson.M{"fetchedat": 1}
+	if m.StreamingTTL > 0 {
+		stale = append(stale,
+			bson.M{"streamingfetchedat": bson.M{"$exists": false}},
+			bson.M{"streamingfetchedat": bson.M{"$lt": now.Add(-m.StreamingTTL)}},
+		)
+		sort = bson.M{"streamingfetchedat": 1}
+	}
+	filter := bson.M{
+		"imdbid": bson.M{"$ne": ""},
+		"$and": bson.A{
+			bson.M{"$or": stale},
+			bson.M{"$or": bson.A{
+				bson.M{"refreshattemptedat": bson.M{"$exists": false}},
+				bson.M{"refreshattemptedat": bson.M{"$lt": now.Add(-refreshRetryDelay)}},
+			}},
+		},
+	}
+	if movieIds != nil {
+		filter["imdbid"] = bson.M{"$ne": "", "$in": movieIds}
+	}
+	findOptions := options.Find().SetSort(sort).SetLimit(int64(limit))
+	cursor, err := m.collection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []MovieResponse
+	if err := cursor.All(context.Background(), &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// MarkRefreshFailed records a failed refresh of the movie, which FetchStale
+// skips for refreshRetryDelay.
+func (m *MongoHandler) MarkRefreshFailed(movieId string) {
+	_, err := m.collection.UpdateOne(context.Background(), bson.M{"imdbid": movieId}, bson.M{"$set": bson.M{"refreshattemptedat": time.Now()}})
+	if err != nil {
+		log.Println("Failed to mark refresh as failed:", err)
+	}
+}
+
+// SaveInCache upserts the given movies. Streaming info is merged per country,
+// so a search for one country keeps the cached links of all others and
+// metadata-only providers keep existing links.
 func (m *MongoHandler) SaveInCache(movies []MovieResponse) {
+	now := time.Now()
 	for _, movie := range movies {
-		// Check if a movie with the same imdbId already exists in the database
-		filter := bson.M{"imdbid": movie.IMDBID}
-		existingMovie := m.collection.FindOne(context.Background(), filter)
-		if existingMovie.Err() == nil {
-			// A movie with the same imdbId already exists, skip saving
+		if movie.IMDBID == "" {
 			continue
 		}
+		movie.FetchedAt = now
+		movie.summarizeSeasons()
+		if movie.StreamingInfo != nil {
+			movie.StreamingFetchedAt = now
+		}
 
-		// No existing movie found, save the current movie
-		_, err := m.collection.InsertOne(context.Background(), movie)
+		filter := bson.M{"imdbid": movie.IMDBID}
+		update, err := cacheUpdate(movie)
+		if err != nil {
+			log.Println("Failed to build cache update:", err)
+			continue
+		}
+		_, err = m.collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			// A concurrent upsert inserted the movie first, so this one can update it
+			_, err = m.collection.UpdateOne(context.Background(), filter, update)
+		}
 		if err != nil {
 			log.Println("Failed to save cache:", err)
-			// Handle the error accordingly
+			continue
+		}
+		if movie.StreamingInfo != nil {
+			m.savePrices(movie, now)
+		}
+	}
+}
+
+// cacheUpdate builds an update pipeline that sets every field of the movie and
+// merges its streaming info into the cached one. Values are wrapped in $literal
+// so strings starting with $ are not read as field paths.
+func cacheUpdate(movie MovieResponse) (mongo.Pipeline, error) {
+	data, err := bson.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	set := bson.D{}
+	for key, value := range fields {
+		if key == "streaminginfo" {
+			continue
 		}
+		set = append(set, bson.E{Key: key, Value: bson.M{"$literal": value}})
+	}
+	if movie.StreamingInfo != nil {
+		set = append(set, bson.E{Key: "streaminginfo", Value: bson.M{"$mergeObjects": bson.A{
+			bson.M{"$ifNull": bson.A{"$streaminginfo", bson.M{}}},
+			bson.M{"$literal": movie.StreamingInfo},
+		}}})
 	}
+	return mongo.Pipeline{{{Key: "$set", Value: set}}}, nil
 }
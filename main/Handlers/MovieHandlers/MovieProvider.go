@@ -0,0 +1,44 @@
+package MovieHandlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrMovieNotFound = errors.New("movie not found")
+
+// MovieProvider is a source of movie metadata and streaming availability.
+// Search results carry streaming info for at least the given country, while
+// Inspect returns every country the provider knows about.
+type MovieProvider interface {
+	Search(ctx context.Context, term, country string) ([]MovieResponse, error)
+	Inspect(ctx context.Context, movieId string) (MovieResponse, error)
+}
+
+// ProvidesStreaming reports whether the named provider returns streaming info.
+// The others only supply metadata, so cached streaming links never get stale
+// with them.
+func ProvidesStreaming(name string) bool {
+	return name == "rapidapi"
+}
+
+// NewMovieProvider creates the provider selected at startup. Network providers
+// send their requests through the upstream client, and calls to paid
+// providers are counted by the quota tracker.
+func NewMovieProvider(name, tmdbToken, fixtureFile string, upstream *UpstreamClient, quota *QuotaTracker) (MovieProvider, error) {
+	switch name {
+	case "rapidapi":
+		upstream.Quota = quota
+		return &RapidApiProvider{Upstream: upstream}, nil
+	case "tmdb":
+		if tmdbToken == "" {
+			return nil, errors.New("tmdb provider requires an api read access token")
+		}
+		return &TmdbProvider{AccessToken: tmdbToken, Upstream: upstream}, nil
+	case "file":
+		return NewFileProvider(fixtureFile)
+	default:
+		return nil, fmt.Errorf("unknown movie provider: %s", name)
+	}
+}
@@ -1,12 +1,18 @@
 package MovieHandlers
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type MovieResponse struct {
-	Type          string
-	Title         string
-	Overview      string
-	StreamingInfo *struct {
-		De map[string][]platformAvailability
-	} `json:"streamingInfo,omitempty"`
+	Type     string
+	Title    string
+	Overview string
+	// StreamingInfo maps a lowercase country code to the offers per streaming service.
+	StreamingInfo streamingInfo `json:"streamingInfo,omitempty" bson:"streaminginfo,omitempty"`
 	Year          int
 	IMDBRating    float64 `json:"imdbRating"`
 	IMDBID        string  `json:"imdbId"`
@@ -25,6 +31,68 @@ type MovieResponse struct {
 	PosterURLs                map[string]string
 	Tagline                   string
 	AdvisedMinimumAudienceAge int
+	// SeasonCount, EpisodeCount and Seasons are only set for series.
+	SeasonCount        int       `json:"seasonCount,omitempty"`
+	EpisodeCount       int       `json:"episodeCount,omitempty"`
+	Seasons            []season  `json:"seasons,omitempty" bson:"seasons,omitempty"`
+	FetchedAt          time.Time `json:"-" bson:"fetchedat,omitempty"`
+	StreamingFetchedAt time.Time `json:"-" bson:"streamingfetchedat,omitempty"`
+}
+
+type season struct {
+	// Number is the 1-based season number of the provider, which is not
+	// necessarily the position in Seasons as series can have gaps. The
+	// specials, season 0 at some providers, are left out.
+	Number        int
+	Type          string
+	Title         string
+	FirstAirYear  int
+	LastAirYear   int
+	EpisodeCount  int           `json:"episodeCount,omitempty"`
+	StreamingInfo streamingInfo `json:"streamingInfo,omitempty" bson:"streaminginfo,omitempty"`
+	Episodes      []episode     `json:"episodes,omitempty" bson:"episodes,omitempty"`
+}
+
+// streamingInfo holds the offers per streaming service of every country. The
+// app reads the country keys capitalized, like "De", which is how the German
+// offers were sent before other countries were supported, so they are
+// capitalized in JSON responses only.
+type streamingInfo map[string]map[string][]platformAvailability
+
+func (s streamingInfo) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	capitalized := make(map[string]map[string][]platformAvailability, len(s))
+	for country, services := range s {
+		if country != "" {
+			country = strings.ToUpper(country[:1]) + country[1:]
+		}
+		capitalized[country] = services
+	}
+	return json.Marshal(capitalized)
+}
+
+// UnmarshalJSON accepts both the lowercase keys of the providers and the
+// capitalized keys of our own responses.
+func (s *streamingInfo) UnmarshalJSON(data []byte) error {
+	var decoded map[string]map[string][]platformAvailability
+	err := json.Unmarshal(data, &decoded)
+	if err != nil || decoded == nil {
+		*s = nil
+		return err
+	}
+	*s = make(streamingInfo, len(decoded))
+	for country, services := range decoded {
+		(*s)[strings.ToLower(country)] = services
+	}
+	return nil
+}
+
+type episode struct {
+	Type    string
+	Title   string
+	AirYear int
 }
 
 type platformAvailability struct {
@@ -36,3 +104,166 @@ type platformAvailability struct {
 		Amount    string
 	} `json:"price,omitempty"`
 }
+
+// ForCountry returns a copy of the movie that only carries the streaming info of the given country.
+func (m MovieResponse) ForCountry(country string) MovieResponse {
+	m.StreamingInfo = filterCountry(m.StreamingInfo, country)
+	if m.Seasons != nil {
+		seasons := make([]season, len(m.Seasons))
+		for index, s := range m.Seasons {
+			s.StreamingInfo = filterCountry(s.StreamingInfo, country)
+			seasons[index] = s
+		}
+		m.Seasons = seasons
+	}
+	return m
+}
+
+// AvailableOn reports whether the movie has an offer on the service in the given country.
+func (m MovieResponse) AvailableOn(country, service string) bool {
+	return len(m.StreamingInfo[country][service]) > 0
+}
+
+// StreamableIn reports whether the movie can be watched on any service in the
+// given country without renting or buying it.
+func (m MovieResponse) StreamableIn(country string) bool {
+	for service := range m.StreamingInfo[country] {
+		if m.StreamableOn(country, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamableOn reports whether the movie can be watched on the service in the
+// given country without renting or buying it.
+func (m MovieResponse) StreamableOn(country, service string) bool {
+	for _, offer := range m.StreamingInfo[country][service] {
+		if offer.Type != "rent" && offer.Type != "buy" {
+			return true
+		}
+	}
+	return false
+}
+
+// LowestPrice returns the cheapest rent or buy offer in the given country on
+// one of the given services, or on any service if none are given.
+func (m MovieResponse) LowestPrice(country string, services []string) (amount float64, service string, formatted string, ok bool) {
+	for offerService, offers := range m.StreamingInfo[country] {
+		if len(services) > 0 && !containsService(services, offerService) {
+			continue
+		}
+		for _, offer := range offers {
+			if offer.Price == nil || (offer.Type != "rent" && offer.Type != "buy") {
+				continue
+			}
+			offerAmount, err := strconv.ParseFloat(offer.Price.Amount, 64)
+			if err != nil {
+				continue
+			}
+			if !ok || offerAmount < amount {
+				amount, service, formatted, ok = offerAmount, offerService, offer.Price.Formatted, true
+			}
+		}
+	}
+	return amount, service, formatted, ok
+}
+
+func containsService(services []string, service string) bool {
+	for _, s := range services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseYear falls back to the first season for series without a year.
+func (m MovieResponse) releaseYear() int {
+	if m.Year == 0 && len(m.Seasons) > 0 {
+		return m.Seasons[0].FirstAirYear
+	}
+	return m.Year
+}
+
+func (m MovieResponse) hasGenre(genre string) bool {
+	for _, g := range m.Genres {
+		if strings.ToLower(g.Name) == genre {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutSeasons returns a copy of the movie without season details, keeping the counts.
+func (m MovieResponse) WithoutSeasons() MovieResponse {
+	m.Seasons = nil
+	return m
+}
+
+// Season returns a copy of the movie that only carries the season with the
+// given 1-based number.
+func (m MovieResponse) Season(number int) (MovieResponse, bool) {
+	if number < 1 {
+		return MovieResponse{}, false
+	}
+	for _, s := range numberSeasons(m.Seasons) {
+		if s.Number == number {
+			m.Seasons = []season{s}
+			return m, true
+		}
+	}
+	return MovieResponse{}, false
+}
+
+// numberSeasons numbers the seasons by their position if none of them carries
+// a number, which is the case for providers without season numbers and for
+// movies cached before the numbers were stored.
+func numberSeasons(seasons []season) []season {
+	for _, s := range seasons {
+		if s.Number != 0 {
+			return seasons
+		}
+	}
+	numbered := make([]season, len(seasons))
+	for index, s := range seasons {
+		s.Number = index + 1
+		numbered[index] = s
+	}
+	return numbered
+}
+
+// summarizeSeasons fills in counts the provider left out.
+func (m *MovieResponse) summarizeSeasons() {
+	if len(m.Seasons) == 0 {
+		return
+	}
+	// Copy the seasons, the slice is shared with responses being served
+	seasons := make([]season, len(m.Seasons))
+	episodeCount := 0
+	for index, s := range numberSeasons(m.Seasons) {
+		if s.EpisodeCount == 0 {
+			s.EpisodeCount = len(s.Episodes)
+		}
+		episodeCount += s.EpisodeCount
+		seasons[index] = s
+	}
+	m.Seasons = seasons
+	if m.SeasonCount == 0 {
+		m.SeasonCount = len(m.Seasons)
+	}
+	if m.EpisodeCount == 0 {
+		m.EpisodeCount = episodeCount
+	}
+}
+
+func filterCountry(info streamingInfo, country string) streamingInfo {
+	if info == nil {
+		return nil
+	}
+	filtered := streamingInfo{}
+	if services, ok := info[country]; ok {
+		filtered[country] = services
+	}
+	return filtered
+}
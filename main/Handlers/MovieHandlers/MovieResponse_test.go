@@ -0,0 +1,50 @@
+package MovieHandlers
+
+import "testing"
+
+func TestSeasonLooksUpNumber(t *testing.T) {
+	movie := MovieResponse{Seasons: []season{
+		{Number: 1, Title: "Season 1"},
+		{Number: 3, Title: "Season 3"},
+	}}
+
+	tests := []struct {
+		number int
+		title  string
+		found  bool
+	}{
+		{0, "", false},
+		{1, "Season 1", true},
+		{2, "", false},
+		{3, "Season 3", true},
+		{4, "", false},
+	}
+	for _, test := range tests {
+		got, ok := movie.Season(test.number)
+		if ok != test.found {
+			t.Errorf("Season(%d) found = %v, want %v", test.number, ok, test.found)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(got.Seasons) != 1 || got.Seasons[0].Title != test.title {
+			t.Errorf("Season(%d) = %+v, want only %q", test.number, got.Seasons, test.title)
+		}
+	}
+	if len(movie.Seasons) != 2 {
+		t.Errorf("Season changed the original movie: %+v", movie.Seasons)
+	}
+}
+
+func TestSeasonFallsBackToPosition(t *testing.T) {
+	movie := MovieResponse{Seasons: []season{{Title: "First"}, {Title: "Second"}}}
+
+	got, ok := movie.Season(2)
+	if !ok || got.Seasons[0].Title != "Second" || got.Seasons[0].Number != 2 {
+		t.Errorf("Season(2) = %+v, %v, want the second season", got.Seasons, ok)
+	}
+	if _, ok = movie.Season(0); ok {
+		t.Error("Season(0) found a season of a series without numbers")
+	}
+}
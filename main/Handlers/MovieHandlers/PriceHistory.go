@@ -0,0 +1,158 @@
+package MovieHandlers
+
+import (
+	"context"
+	"errors"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPriceHistory caps the observations a single price history request returns.
+const maxPriceHistory = 500
+
+type priceObservation struct {
+	ObservedAt time.Time `json:"observedAt"`
+	Meta       priceMeta `json:"meta"`
+	Amount     float64   `json:"amount"`
+	Formatted  string    `json:"formatted"`
+}
+
+type priceMeta struct {
+	IMDBID  string `json:"imdbId"`
+	Country string `json:"country"`
+	Service string `json:"service"`
+	Type    string `json:"type"`
+	Quality string `json:"quality"`
+}
+
+// ensurePriceCollection creates the time-series collection holding the price history.
+func (m *MongoHandler) ensurePriceCollection() {
+	timeSeries := options.TimeSeries().SetTimeField("observedat").SetMetaField("meta").SetGranularity("hours")
+	err := m.client.Database("mr-cache").CreateCollection(context.Background(), "prices", options.CreateCollection().SetTimeSeriesOptions(timeSeries))
+	var commandError mongo.CommandError
+	if err != nil && !(errors.As(err, &commandError) && commandError.Name == "NamespaceExists") {
+		log.Println("Failed to create price collection:", err)
+	}
+}
+
+// savePrices appends the rent and buy prices of a freshly fetched movie to the history.
+func (m *MongoHandler) savePrices(movie MovieResponse, observedAt time.Time) {
+	observations := make([]interface{}, 0)
+	for country, services := range movie.StreamingInfo {
+		for service, offers := range services {
+			for _, offer := range offers {
+				if offer.Price == nil {
+					continue
+				}
+				amount, err := strconv.ParseFloat(offer.Price.Amount, 64)
+				if err != nil {
+					continue
+				}
+				observations = append(observations, priceObservation{
+					ObservedAt: observedAt,
+					Meta: priceMeta{
+						IMDBID:  movie.IMDBID,
+						Country: country,
+						Service: service,
+						Type:    offer.Type,
+						Quality: offer.Quality,
+					},
+					Amount:    amount,
+					Formatted: offer.Price.Formatted,
+				})
+			}
+		}
+	}
+	if len(observations) == 0 {
+		return
+	}
+	_, err := m.prices.InsertMany(context.Background(), observations)
+	if err != nil {
+		log.Println("Failed to save prices:", err)
+	}
+}
+
+// FetchPriceHistory returns the latest limit price observations of a movie
+// since the given time, oldest first. Empty service or country match all of them.
+func (m *MongoHandler) FetchPriceHistory(movieId, country, service string, since time.Time, limit int) ([]priceObservation, error) {
+	filter := bson.M{"meta.imdbid": movieId, "observedat": bson.M{"$gte": since}}
+	if country != "" {
+		filter["meta.country"] = country
+	}
+	if service != "" {
+		filter["meta.service"] = service
+	}
+	findOptions := options.Find().SetSort(bson.M{"observedat": -1}).SetLimit(int64(limit))
+	cursor, err := m.prices.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	observations := make([]priceObservation, 0)
+	if err := cursor.All(context.Background(), &observations); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(observations)-1; i < j; i, j = i+1, j-1 {
+		observations[i], observations[j] = observations[j], observations[i]
+	}
+	return observations, nil
+}
+
+// PriceHandler serves the rent and buy price history of a title. The since
+// parameter (RFC 3339) and the limit parameter, at most maxPriceHistory,
+// narrow it down to the latest observations.
+type PriceHandler struct {
+	AuthHandler *auth.Client
+	Mongo       *MongoHandler
+}
+
+func (p *PriceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorized, _ := Handlers.AuthorizationWrapper(w, r, p.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	movieId := r.URL.Query().Get("movieId")
+	if movieId == "" {
+		http.Error(w, "Movie ID is required", http.StatusBadRequest)
+		return
+	}
+	country := strings.ToLower(r.URL.Query().Get("country"))
+	service := strings.ToLower(r.URL.Query().Get("service"))
+	var since time.Time
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	limit := maxPriceHistory
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+
+	observations, err := p.Mongo.FetchPriceHistory(movieId, country, service, since, limit)
+	if err != nil {
+		log.Println("Failed to fetch price history:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	Handlers.WriteJSON(w, observations)
+}
@@ -0,0 +1,158 @@
+package MovieHandlers
+
+import (
+	"context"
+	"errors"
+	"firebase.google.com/go/v4/auth"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("provider budget exhausted")
+
+// QuotaTracker counts the calls made to a paid provider per day and month,
+// persisting the counters in mongo so they survive restarts.
+type QuotaTracker struct {
+	collection *mongo.Collection
+	// DailyBudget and MonthlyBudget are the calls we are willing to pay for, 0 means unlimited.
+	DailyBudget   int
+	MonthlyBudget int
+	// Threshold is the used fraction of a budget at which the movie handlers switch to cache-only mode.
+	Threshold float64
+	mutex     sync.Mutex
+	day       quotaCounter
+	month     quotaCounter
+	limit     int
+	remaining int
+}
+
+type quotaCounter struct {
+	Id     string `bson:"_id" json:"id"`
+	Period string `bson:"period" json:"period"`
+	Count  int    `bson:"count" json:"count"`
+	Budget int    `bson:"-" json:"budget"`
+}
+
+type quotaStatus struct {
+	Day       quotaCounter `json:"day"`
+	Month     quotaCounter `json:"month"`
+	Limit     int          `json:"limit"`
+	Remaining int          `json:"remaining"`
+	CacheOnly bool         `json:"cacheOnly"`
+}
+
+func NewQuotaTracker(m *MongoHandler) *QuotaTracker {
+	return &QuotaTracker{
+		collection: m.client.Database("mr-cache").Collection("quota"),
+		Threshold:  1,
+		remaining:  -1,
+	}
+}
+
+// rollOver resets the in-memory counters when a new day or month started,
+// loading what is persisted for it. The mutex must be held.
+func (q *QuotaTracker) rollOver() {
+	now := time.Now().UTC()
+	if dayId := "day:" + now.Format("2006-01-02"); q.day.Id != dayId {
+		q.day = q.load(dayId, "day")
+	}
+	if monthId := "month:" + now.Format("2006-01"); q.month.Id != monthId {
+		q.month = q.load(monthId, "month")
+	}
+}
+
+func (q *QuotaTracker) load(id, period string) quotaCounter {
+	counter := quotaCounter{Id: id, Period: period}
+	err := q.collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&counter)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println("Failed to load quota:", err)
+	}
+	return counter
+}
+
+func exceeds(count, budget int, threshold float64) bool {
+	return budget > 0 && float64(count) >= float64(budget)*threshold
+}
+
+// Allow reports whether another provider call fits into the budget.
+func (q *QuotaTracker) Allow() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.rollOver()
+	return !q.cacheOnly()
+}
+
+// cacheOnly must be called with the mutex held.
+func (q *QuotaTracker) cacheOnly() bool {
+	if exceeds(q.day.Count, q.DailyBudget, q.Threshold) || exceeds(q.month.Count, q.MonthlyBudget, q.Threshold) {
+		return true
+	}
+	// The provider's own plan limit, as reported by the rate-limit headers
+	return q.limit > 0 && q.remaining >= 0 && exceeds(q.limit-q.remaining, q.limit, q.Threshold)
+}
+
+// Record counts a provider call and remembers the rate-limit headers of its response.
+func (q *QuotaTracker) Record(res *http.Response) {
+	q.mutex.Lock()
+	q.rollOver()
+	q.day.Count++
+	q.month.Count++
+	if limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Requests-Limit")); err == nil {
+		q.limit = limit
+	}
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Requests-Remaining")); err == nil {
+		q.remaining = remaining
+	}
+	counters := []quotaCounter{q.day, q.month}
+	limit, remaining := q.limit, q.remaining
+	q.mutex.Unlock()
+
+	for _, counter := range counters {
+		update := bson.M{
+			"$inc": bson.M{"count": 1},
+			"$set": bson.M{"period": counter.Period, "limit": limit, "remaining": remaining, "updatedat": time.Now()},
+		}
+		_, err := q.collection.UpdateOne(context.Background(), bson.M{"_id": counter.Id}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			log.Println("Failed to save quota:", err)
+		}
+	}
+}
+
+func (q *QuotaTracker) status() quotaStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.rollOver()
+	status := quotaStatus{
+		Day:       q.day,
+		Month:     q.month,
+		Limit:     q.limit,
+		Remaining: q.remaining,
+		CacheOnly: q.cacheOnly(),
+	}
+	status.Day.Budget = q.DailyBudget
+	status.Month.Budget = q.MonthlyBudget
+	return status
+}
+
+// QuotaHandler exposes the provider usage to admins.
+type QuotaHandler struct {
+	AuthHandler *auth.Client
+	Quota       *QuotaTracker
+}
+
+func (qh *QuotaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorized, _ := Handlers.AdminWrapper(w, r, qh.AuthHandler)
+	if !authorized {
+		return
+	}
+
+	Handlers.WriteJSON(w, qh.Quota.status())
+}
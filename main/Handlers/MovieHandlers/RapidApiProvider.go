@@ -0,0 +1,84 @@
+package MovieHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+type externalSearchMovieResponse struct {
+	Result []MovieResponse `json:"result"`
+}
+
+type externalInspectMovieResponse struct {
+	Result MovieResponse `json:"result"`
+}
+
+type RapidApiProvider struct {
+	Upstream *UpstreamClient
+}
+
+func (p *RapidApiProvider) get(ctx context.Context, requestUrl string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("x-rapidapi-key", Handlers.ApiKey)
+	req.Header.Add("x-rapidapi-host", Handlers.ApiHost)
+
+	res, err := p.Upstream.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(res.Body)
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrMovieNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d", res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// Search expects Handlers.SearchUrl to end with the search term parameter and
+// to leave out the country, which is appended per request.
+func (p *RapidApiProvider) Search(ctx context.Context, term, country string) ([]MovieResponse, error) {
+	body, err := p.get(ctx, Handlers.SearchUrl+url.QueryEscape(term)+"&country="+url.QueryEscape(country))
+	if err != nil {
+		return nil, err
+	}
+
+	var movieResponse externalSearchMovieResponse
+	log.Println("Got movies from api")
+	err = json.Unmarshal(body, &movieResponse)
+	if err != nil {
+		return nil, err
+	}
+	return movieResponse.Result, nil
+}
+
+func (p *RapidApiProvider) Inspect(ctx context.Context, movieId string) (MovieResponse, error) {
+	body, err := p.get(ctx, Handlers.InspectUrl+url.QueryEscape(movieId))
+	if err != nil {
+		return MovieResponse{}, err
+	}
+
+	var movieResponse externalInspectMovieResponse
+	log.Println("Got movie from api")
+	err = json.Unmarshal(body, &movieResponse)
+	if err != nil {
+		return MovieResponse{}, err
+	}
+	return movieResponse.Result, nil
+}
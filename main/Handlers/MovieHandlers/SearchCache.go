@@ -0,0 +1,82 @@
+package MovieHandlers
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"strings"
+	"time"
+)
+
+type cachedSearch struct {
+	Query     string
+	IMDBIDs   []string
+	ExpiresAt time.Time
+}
+
+// normalizeQuery maps equivalent search terms like " Batman" and "batman" onto one cache key.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// searchCacheKey scopes a query to a country, as providers may only return
+// titles that are available there.
+func searchCacheKey(query, country string) string {
+	return country + ":" + normalizeQuery(query)
+}
+
+// FetchSearchFromCache returns the cached results of a search in their original
+// order. The second return value is false on a miss, including when one of the
+// movies has since disappeared from the movie cache.
+func (m *MongoHandler) FetchSearchFromCache(query, country string) ([]MovieResponse, bool, error) {
+	filter := bson.M{"query": searchCacheKey(query, country), "expiresat": bson.M{"$gt": time.Now()}}
+	var search cachedSearch
+	err := m.searches.FindOne(context.Background(), filter).Decode(&search)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	cached, err := m.FetchManyFromCache(search.IMDBIDs)
+	if err != nil {
+		return nil, false, err
+	}
+	movies := make([]MovieResponse, 0, len(search.IMDBIDs))
+	for _, id := range search.IMDBIDs {
+		movie, ok := cached[id]
+		if !ok {
+			return nil, false, nil
+		}
+		movies = append(movies, movie)
+	}
+	return movies, true, nil
+}
+
+// SaveSearchInCache remembers which movies a search returned. The movies
+// themselves are stored through SaveInCache. Searches without results are not
+// cached, so a title the provider missed once is not hidden for the whole TTL.
+func (m *MongoHandler) SaveSearchInCache(query, country string, movies []MovieResponse) {
+	ids := make([]string, 0, len(movies))
+	for _, movie := range movies {
+		if movie.IMDBID != "" {
+			ids = append(ids, movie.IMDBID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	search := cachedSearch{
+		Query:     searchCacheKey(query, country),
+		IMDBIDs:   ids,
+		ExpiresAt: time.Now().Add(m.SearchTTL),
+	}
+	filter := bson.M{"query": search.Query}
+	_, err := m.searches.ReplaceOne(context.Background(), filter, search, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Println("Failed to save search cache:", err)
+	}
+}
@@ -0,0 +1,122 @@
+package MovieHandlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxPageSize caps the limit parameter of a paginated search.
+const maxPageSize = 100
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+type searchFilter struct {
+	movieType     string
+	yearFrom      int
+	yearTo        int
+	genre         string
+	language      string
+	minIMDBRating float64
+	minTMDBRating float64
+	service       string
+	country       string
+}
+
+func parseSearchFilter(query url.Values, country string) (searchFilter, error) {
+	filter := searchFilter{
+		movieType: strings.ToLower(query.Get("type")),
+		genre:     strings.ToLower(query.Get("genre")),
+		language:  strings.ToLower(query.Get("language")),
+		service:   strings.ToLower(query.Get("service")),
+		country:   country,
+	}
+	if filter.movieType != "" && filter.movieType != "movie" && filter.movieType != "series" {
+		return searchFilter{}, errors.New("type must be movie or series")
+	}
+
+	var err error
+	if value := query.Get("yearFrom"); value != "" {
+		if filter.yearFrom, err = strconv.Atoi(value); err != nil {
+			return searchFilter{}, errors.New("invalid yearFrom")
+		}
+	}
+	if value := query.Get("yearTo"); value != "" {
+		if filter.yearTo, err = strconv.Atoi(value); err != nil {
+			return searchFilter{}, errors.New("invalid yearTo")
+		}
+	}
+	if value := query.Get("minImdbRating"); value != "" {
+		if filter.minIMDBRating, err = strconv.ParseFloat(value, 64); err != nil {
+			return searchFilter{}, errors.New("invalid minImdbRating")
+		}
+	}
+	if value := query.Get("minTmdbRating"); value != "" {
+		if filter.minTMDBRating, err = strconv.ParseFloat(value, 64); err != nil {
+			return searchFilter{}, errors.New("invalid minTmdbRating")
+		}
+	}
+	return filter, nil
+}
+
+func (f searchFilter) matches(movie MovieResponse) bool {
+	if f.movieType != "" && movie.Type != f.movieType {
+		return false
+	}
+	year := movie.releaseYear()
+	if f.yearFrom != 0 && year < f.yearFrom {
+		return false
+	}
+	if f.yearTo != 0 && (year == 0 || year > f.yearTo) {
+		return false
+	}
+	if f.language != "" && strings.ToLower(movie.OriginalLanguage) != f.language {
+		return false
+	}
+	if movie.IMDBRating < f.minIMDBRating || movie.TMDBRating < f.minTMDBRating {
+		return false
+	}
+	if f.genre != "" && !movie.hasGenre(f.genre) {
+		return false
+	}
+	if f.service != "" && !movie.AvailableOn(f.country, f.service) {
+		return false
+	}
+	return true
+}
+
+func (f searchFilter) apply(movies []MovieResponse) []MovieResponse {
+	filtered := make([]MovieResponse, 0, len(movies))
+	for _, movie := range movies {
+		if f.matches(movie) {
+			filtered = append(filtered, movie)
+		}
+	}
+	return filtered
+}
+
+// paginate returns the page starting at the cursor and the cursor of the next
+// page, which is empty on the last page. A limit of 0 returns everything.
+func paginate(movies []MovieResponse, cursor string, limit int) ([]MovieResponse, string, error) {
+	offset := 0
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", errInvalidCursor
+		}
+		offset, err = strconv.Atoi(string(decoded))
+		if err != nil || offset < 0 {
+			return nil, "", errInvalidCursor
+		}
+	}
+	if offset > len(movies) {
+		offset = len(movies)
+	}
+	if limit <= 0 || offset+limit >= len(movies) {
+		return movies[offset:], "", nil
+	}
+	next := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset + limit)))
+	return movies[offset : offset+limit], next, nil
+}
@@ -1,66 +1,97 @@
 package MovieHandlers
 
 import (
-	"encoding/json"
+	"context"
 	"github.com/ItzBubschki/mr-backend/main/Handlers"
-	"io"
 	"log"
 	"net/http"
+	"strconv"
 )
 
-type externalSearchMovieResponse struct {
-	Result []MovieResponse `json:"result"`
-}
+const (
+	// degradedSearchLimit caps the local results served while the provider is unavailable.
+	degradedSearchLimit = 20
+	// degradedScanLimit caps the local matches the filters are applied to.
+	degradedScanLimit = 500
+)
 
 type SearchHandler struct {
-	Mongo *MongoHandler
+	Mongo     *MongoHandler
+	Provider  MovieProvider
+	Countries CountryResolver
 }
 
-func (s *SearchHandler) searchForTerm(search string) []MovieResponse {
-	url := Handlers.SearchUrl + search
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("x-rapidapi-key", Handlers.ApiKey)
-	req.Header.Add("x-rapidapi-host", Handlers.ApiHost)
-
-	res, _ := http.DefaultClient.Do(req)
-
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			return
-		}
-	}(res.Body)
-	body, _ := io.ReadAll(res.Body)
-
-	var movieResponse externalSearchMovieResponse
-	log.Println("Got movies from api")
-	err := json.Unmarshal(body, &movieResponse)
+func (s *SearchHandler) searchForTerm(ctx context.Context, search, country string) ([]MovieResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamDeadline)
+	defer cancel()
+	movies, err := s.Provider.Search(ctx, search, country)
 	if err != nil {
-		return []MovieResponse{}
+		return nil, err
 	}
 
-	go s.Mongo.SaveInCache(movieResponse.Result)
+	go func() {
+		s.Mongo.SaveInCache(movies)
+		s.Mongo.SaveSearchInCache(search, country, movies)
+	}()
 
-	return movieResponse.Result
+	return movies, nil
 }
 
 func (s *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	search := r.URL.Query().Get("search")
-	movies := s.searchForTerm(search)
-
-	// Convert response to JSON
-	jsonResponse, err := json.Marshal(movies)
+	country := requestCountry(r, s.Countries)
+	filter, err := parseSearchFilter(r.URL.Query(), country)
 	if err != nil {
-		log.Println("Failed to marshal JSON response:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Set response headers and write JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(jsonResponse)
+	movies, found, err := s.Mongo.FetchSearchFromCache(search, country)
 	if err != nil {
+		log.Println("Failed to fetch search from cache:", err)
+	}
+	if found {
+		log.Println("Found search in cache")
+	} else {
+		movies, err = s.searchForTerm(r.Context(), search, country)
+		if err != nil {
+			log.Println("Failed to search for movies, falling back to cache:", err)
+			w.Header().Set("X-Degraded-Mode", "true")
+			movies, err = s.Mongo.SearchInCache(search, degradedScanLimit)
+			if err != nil {
+				log.Println("Failed to search in cache:", err)
+				movies = []MovieResponse{}
+			}
+			// Filter before limiting, so matches further down are not lost
+			movies = filter.apply(movies)
+			if len(movies) > degradedSearchLimit {
+				movies = movies[:degradedSearchLimit]
+			}
+		}
+	}
+
+	page, next, err := paginate(filter.apply(movies), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	// Copy the results, the provider's slice is still being saved in the background
+	response := make([]MovieResponse, 0, len(page))
+	for _, movie := range page {
+		response = append(response, movie.WithoutSeasons().ForCountry(country))
+	}
+
+	Handlers.WriteJSON(w, response)
 }
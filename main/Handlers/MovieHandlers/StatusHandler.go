@@ -0,0 +1,15 @@
+package MovieHandlers
+
+import (
+	"github.com/ItzBubschki/mr-backend/main/Handlers"
+	"net/http"
+)
+
+// StatusHandler reports the health of the upstream movie provider.
+type StatusHandler struct {
+	Upstream *UpstreamClient
+}
+
+func (sh *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Handlers.WriteJSON(w, sh.Upstream.status())
+}
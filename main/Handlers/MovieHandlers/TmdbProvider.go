@@ -0,0 +1,247 @@
+package MovieHandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	tmdbBaseUrl      = "https://api.themoviedb.org/3"
+	tmdbImageBaseUrl = "https://image.tmdb.org/t/p/"
+	// tmdbSearchLimit caps the detail lookups per search, since TMDB search
+	// results carry no imdb id.
+	tmdbSearchLimit = 10
+)
+
+var tmdbPosterSizes = []string{"92", "154", "185", "342", "500", "780"}
+
+// TmdbProvider serves movie metadata from The Movie Database. TMDB has no
+// streaming links, so StreamingInfo is always left empty.
+type TmdbProvider struct {
+	// AccessToken is the API read access token, which is sent as a header so it
+	// never ends up in logged urls.
+	AccessToken string
+	Upstream    *UpstreamClient
+}
+
+type tmdbSearchResponse struct {
+	Results []struct {
+		Id        int    `json:"id"`
+		MediaType string `json:"media_type"`
+	} `json:"results"`
+}
+
+type tmdbFindResponse struct {
+	MovieResults []struct {
+		Id int `json:"id"`
+	} `json:"movie_results"`
+	TvResults []struct {
+		Id int `json:"id"`
+	} `json:"tv_results"`
+}
+
+type tmdbDetails struct {
+	Title            string  `json:"title"`
+	Name             string  `json:"name"`
+	OriginalTitle    string  `json:"original_title"`
+	OriginalName     string  `json:"original_name"`
+	Overview         string  `json:"overview"`
+	Tagline          string  `json:"tagline"`
+	ReleaseDate      string  `json:"release_date"`
+	FirstAirDate     string  `json:"first_air_date"`
+	LastAirDate      string  `json:"last_air_date"`
+	NumberOfSeasons  int     `json:"number_of_seasons"`
+	NumberOfEpisodes int     `json:"number_of_episodes"`
+	VoteAverage      float64 `json:"vote_average"`
+	OriginalLanguage string  `json:"original_language"`
+	Runtime          int     `json:"runtime"`
+	EpisodeRunTime   []int   `json:"episode_run_time"`
+	BackdropPath     string  `json:"backdrop_path"`
+	PosterPath       string  `json:"poster_path"`
+	ImdbId           string  `json:"imdb_id"`
+	Genres           []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	Seasons []struct {
+		SeasonNumber int    `json:"season_number"`
+		Name         string `json:"name"`
+		EpisodeCount int    `json:"episode_count"`
+		AirDate      string `json:"air_date"`
+	} `json:"seasons"`
+	ExternalIds struct {
+		ImdbId string `json:"imdb_id"`
+	} `json:"external_ids"`
+	Videos struct {
+		Results []struct {
+			Key  string `json:"key"`
+			Site string `json:"site"`
+			Type string `json:"type"`
+		} `json:"results"`
+	} `json:"videos"`
+}
+
+func (p *TmdbProvider) get(ctx context.Context, path string, query url.Values, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", tmdbBaseUrl+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.AccessToken)
+	res, err := p.Upstream.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			return
+		}
+	}(res.Body)
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrMovieNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("received status code %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+func (p *TmdbProvider) details(ctx context.Context, mediaType string, id int) (MovieResponse, error) {
+	var details tmdbDetails
+	err := p.get(ctx, fmt.Sprintf("/%s/%d", mediaType, id), url.Values{"append_to_response": {"external_ids,videos"}}, &details)
+	if err != nil {
+		return MovieResponse{}, err
+	}
+	return details.toMovieResponse(mediaType), nil
+}
+
+func (p *TmdbProvider) Search(ctx context.Context, term, _ string) ([]MovieResponse, error) {
+	var search tmdbSearchResponse
+	err := p.get(ctx, "/search/multi", url.Values{"query": {term}}, &search)
+	if err != nil {
+		return nil, err
+	}
+
+	movies := make([]MovieResponse, 0)
+	for _, result := range search.Results {
+		if len(movies) == tmdbSearchLimit {
+			break
+		}
+		if result.MediaType != "movie" && result.MediaType != "tv" {
+			continue
+		}
+		movie, err := p.details(ctx, result.MediaType, result.Id)
+		if err != nil {
+			log.Printf("Failed to get tmdb details for %d: %v", result.Id, err)
+			continue
+		}
+		if movie.IMDBID == "" {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	log.Println("Got movies from tmdb")
+	return movies, nil
+}
+
+func (p *TmdbProvider) Inspect(ctx context.Context, movieId string) (MovieResponse, error) {
+	var find tmdbFindResponse
+	err := p.get(ctx, "/find/"+url.PathEscape(movieId), url.Values{"external_source": {"imdb_id"}}, &find)
+	if err != nil {
+		return MovieResponse{}, err
+	}
+
+	var movie MovieResponse
+	if len(find.MovieResults) > 0 {
+		movie, err = p.details(ctx, "movie", find.MovieResults[0].Id)
+	} else if len(find.TvResults) > 0 {
+		movie, err = p.details(ctx, "tv", find.TvResults[0].Id)
+	} else {
+		return MovieResponse{}, ErrMovieNotFound
+	}
+	if err != nil {
+		return MovieResponse{}, err
+	}
+	log.Println("Got movie from tmdb")
+	return movie, nil
+}
+
+func (d tmdbDetails) toMovieResponse(mediaType string) MovieResponse {
+	movie := MovieResponse{
+		Type:             "movie",
+		Title:            d.Title,
+		OriginalTitle:    d.OriginalTitle,
+		Overview:         d.Overview,
+		Tagline:          d.Tagline,
+		TMDBRating:       d.VoteAverage * 10,
+		IMDBID:           d.ImdbId,
+		OriginalLanguage: d.OriginalLanguage,
+		Runtime:          d.Runtime,
+	}
+	releaseDate := d.ReleaseDate
+	if mediaType == "tv" {
+		movie.Type = "series"
+		movie.Title = d.Name
+		movie.OriginalTitle = d.OriginalName
+		releaseDate = d.FirstAirDate
+		if len(d.EpisodeRunTime) > 0 {
+			movie.Runtime = d.EpisodeRunTime[0]
+		}
+		movie.SeasonCount = d.NumberOfSeasons
+		movie.EpisodeCount = d.NumberOfEpisodes
+		for _, tmdbSeason := range d.Seasons {
+			// Season 0 holds the specials
+			if tmdbSeason.SeasonNumber == 0 {
+				continue
+			}
+			year := parseYear(tmdbSeason.AirDate)
+			movie.Seasons = append(movie.Seasons, season{
+				Number:       tmdbSeason.SeasonNumber,
+				Type:         "season",
+				Title:        tmdbSeason.Name,
+				FirstAirYear: year,
+				LastAirYear:  year,
+				EpisodeCount: tmdbSeason.EpisodeCount,
+			})
+		}
+	}
+	if movie.IMDBID == "" {
+		movie.IMDBID = d.ExternalIds.ImdbId
+	}
+	movie.Year = parseYear(releaseDate)
+	for _, genre := range d.Genres {
+		movie.Genres = append(movie.Genres, struct{ Name string }{Name: genre.Name})
+	}
+	if d.BackdropPath != "" {
+		movie.BackdropURLs.Original = tmdbImageBaseUrl + "original" + d.BackdropPath
+	}
+	if d.PosterPath != "" {
+		movie.PosterURLs = map[string]string{"original": tmdbImageBaseUrl + "original" + d.PosterPath}
+		for _, size := range tmdbPosterSizes {
+			movie.PosterURLs[size] = tmdbImageBaseUrl + "w" + size + d.PosterPath
+		}
+	}
+	for _, video := range d.Videos.Results {
+		if video.Site == "YouTube" && video.Type == "Trailer" {
+			movie.YoutubeTrailerVideoID = video.Key
+			movie.YoutubeTrailerVideoLink = "https://www.youtube.com/watch?v=" + video.Key
+			break
+		}
+	}
+	return movie
+}
+
+// parseYear extracts the year of a TMDB date like 2006-01-02.
+func parseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, _ := strconv.Atoi(date[:4])
+	return year
+}
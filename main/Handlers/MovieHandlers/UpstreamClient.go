@@ -0,0 +1,237 @@
+package MovieHandlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// upstreamDeadline bounds a provider call including all of its retries.
+const upstreamDeadline = 30 * time.Second
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// UpstreamClient is the http client shared by the providers. It bounds every
+// attempt with a timeout, retries rate limits and server errors with
+// exponential backoff and stops calling an upstream that keeps failing.
+type UpstreamClient struct {
+	Name string
+	// Timeout bounds a single attempt, the caller's context bounds the whole call.
+	Timeout     time.Duration
+	MaxRetries  int
+	BaseBackoff time.Duration
+	// MaxBackoff caps the delay between attempts, including a Retry-After of the upstream.
+	MaxBackoff time.Duration
+	// FailureThreshold consecutive failures open the circuit for OpenDuration.
+	FailureThreshold int
+	OpenDuration     time.Duration
+	// Quota counts every attempt against the provider budget, if set.
+	Quota    *QuotaTracker
+	client   *http.Client
+	mutex    sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+type upstreamStatus struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+}
+
+func NewUpstreamClient(name string) *UpstreamClient {
+	return &UpstreamClient{
+		Name:             name,
+		Timeout:          10 * time.Second,
+		MaxRetries:       3,
+		BaseBackoff:      500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+		client:           &http.Client{},
+		state:            circuitClosed,
+	}
+}
+
+// setState must be called with the mutex held.
+func (u *UpstreamClient) setState(state string) {
+	if u.state == state {
+		return
+	}
+	log.Printf("Circuit breaker for %s changed from %s to %s", u.Name, u.state, state)
+	u.state = state
+	if state == circuitOpen {
+		u.openedAt = time.Now()
+	}
+}
+
+// allow reports whether a request may be sent. While half-open only a single
+// probe is let through.
+func (u *UpstreamClient) allow() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	switch u.state {
+	case circuitOpen:
+		if time.Since(u.openedAt) < u.OpenDuration {
+			return false
+		}
+		u.setState(circuitHalfOpen)
+		u.probing = true
+		return true
+	case circuitHalfOpen:
+		if u.probing {
+			return false
+		}
+		u.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// release lets the next probe through without counting the call, for calls
+// that ended without an answer of the upstream.
+func (u *UpstreamClient) release() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.probing = false
+}
+
+func (u *UpstreamClient) recordResult(success bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.probing = false
+	if success {
+		u.failures = 0
+		u.setState(circuitClosed)
+		return
+	}
+	u.failures++
+	if u.state == circuitHalfOpen || u.failures >= u.FailureThreshold {
+		u.setState(circuitOpen)
+	}
+}
+
+func (u *UpstreamClient) status() upstreamStatus {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	status := upstreamStatus{Name: u.Name, State: u.state, ConsecutiveFailures: u.failures}
+	if u.state != circuitClosed {
+		openedAt := u.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryDelay honors a Retry-After header in seconds or as a date and falls
+// back to exponential backoff, either capped at MaxBackoff.
+func (u *UpstreamClient) retryDelay(res *http.Response, attempt int) time.Duration {
+	delay := u.BaseBackoff << attempt
+	if res != nil {
+		if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			} else if date, err := http.ParseTime(retryAfter); err == nil {
+				delay = time.Until(date)
+			}
+		}
+	}
+	if delay > u.MaxBackoff {
+		delay = u.MaxBackoff
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// cancelOnClose releases the attempt's context once the body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// Do sends the request, retrying as configured. A response with a retryable
+// status is returned as is once the retries are used up. The circuit breaker
+// counts the call once, no matter how many attempts it took, and not at all
+// if the caller canceled it.
+func (u *UpstreamClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if !u.allow() {
+		return nil, ErrCircuitOpen
+	}
+	res, err := u.do(ctx, req)
+	if errors.Is(err, context.Canceled) || err == ErrQuotaExceeded {
+		u.release()
+	} else {
+		u.recordResult(err == nil && !retryable(res.StatusCode))
+	}
+	return res, err
+}
+
+func (u *UpstreamClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if u.Quota != nil && !u.Quota.Allow() {
+			return nil, ErrQuotaExceeded
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, u.Timeout)
+		res, err := u.client.Do(req.Clone(attemptCtx))
+		if res != nil && u.Quota != nil {
+			u.Quota.Record(res)
+		}
+		failed := err != nil || retryable(res.StatusCode)
+		if !failed {
+			res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+		if attempt == u.MaxRetries || ctx.Err() != nil {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+
+		delay := u.retryDelay(res, attempt)
+		if err != nil {
+			log.Printf("Request to %s failed, retrying in %v: %v", u.Name, delay, err)
+		} else {
+			log.Printf("Request to %s returned %d, retrying in %v", u.Name, res.StatusCode, delay)
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package MovieHandlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	u := NewUpstreamClient("test")
+	res := &http.Response{Header: http.Header{"Retry-After": {"3600"}}}
+	if delay := u.retryDelay(res, 0); delay != u.MaxBackoff {
+		t.Errorf("retryDelay with a Retry-After of an hour = %v, want %v", delay, u.MaxBackoff)
+	}
+	if delay := u.retryDelay(nil, 20); delay != u.MaxBackoff {
+		t.Errorf("retryDelay of attempt 20 = %v, want %v", delay, u.MaxBackoff)
+	}
+	res.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	if delay := u.retryDelay(res, 0); delay != 0 {
+		t.Errorf("retryDelay with a past Retry-After = %v, want 0", delay)
+	}
+}
+
+func TestDoCountsOneFailurePerCall(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	u := NewUpstreamClient("test")
+	u.MaxRetries = 2
+	u.BaseBackoff = time.Millisecond
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	res, err := u.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	_ = res.Body.Close()
+	if attempts != 3 {
+		t.Errorf("Do made %d attempts, want 3", attempts)
+	}
+	if failures := u.status().ConsecutiveFailures; failures != 1 {
+		t.Errorf("Do recorded %d failures, want 1", failures)
+	}
+}
+
+func TestDoIgnoresCanceledCalls(t *testing.T) {
+	u := NewUpstreamClient("test")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:0", nil)
+	_, err := u.Do(ctx, req)
+	if err == nil {
+		t.Fatal("Do succeeded with a canceled context")
+	}
+	if failures := u.status().ConsecutiveFailures; failures != 0 {
+		t.Errorf("Do recorded %d failures for a canceled call, want 0", failures)
+	}
+}
@@ -10,24 +10,89 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
 	mongoHost := flag.String("mongoHost", "mongo", "the host of the mongo database")
 	emulator := flag.Bool("emulator", false, "whether to use the firebase emulator")
+	providerName := flag.String("provider", "rapidapi", "the movie provider to use: rapidapi, tmdb or file")
+	tmdbToken := flag.String("tmdbToken", "", "the api read access token for the tmdb provider")
+	fixtureFile := flag.String("fixtureFile", "db-backup.json", "the fixture file for the file provider")
+	streamingTTL := flag.Duration("streamingTTL", 24*time.Hour, "how long cached streaming info is considered fresh")
+	metadataTTL := flag.Duration("metadataTTL", 30*24*time.Hour, "how long cached movie metadata is considered fresh")
+	searchTTL := flag.Duration("searchTTL", 24*time.Hour, "how long cached search results are served")
+	refreshBudget := flag.Int("refreshBudget", 100, "the daily number of provider calls the cache refresher may use")
+	refreshInterval := flag.Duration("refreshInterval", time.Hour, "how often the cache refresher looks for stale movies")
+	dailyBudget := flag.Int("dailyBudget", 0, "the daily number of rapidapi calls we pay for, 0 for unlimited")
+	monthlyBudget := flag.Int("monthlyBudget", 0, "the monthly number of rapidapi calls we pay for, 0 for unlimited")
+	budgetThreshold := flag.Float64("budgetThreshold", 0.9, "the used fraction of a budget at which the movie handlers only serve the cache")
+	upstreamTimeout := flag.Duration("upstreamTimeout", 10*time.Second, "the timeout of a single request to the movie provider")
+	upstreamRetries := flag.Int("upstreamRetries", 3, "how often a failed request to the movie provider is retried")
+	availabilityInterval := flag.Duration("availabilityInterval", time.Hour, "how often followed titles are checked for new streaming offers")
+	pollInterval := flag.Duration("pollInterval", time.Minute, "how often polls are checked for passed deadlines")
+	deletionInterval := flag.Duration("deletionInterval", 10*time.Minute, "how often failed account deletions are retried")
+	storageBucket := flag.String("storageBucket", "", "the cloud storage bucket holding profile pictures, empty to leave them untouched")
+	purgeInterval := flag.Duration("purgeInterval", time.Hour, "how often archives past their grace period are purged")
+	purgeDryRun := flag.Bool("purgeDryRun", false, "only audit what the purge of expired archives would delete")
+	restoreInterval := flag.Duration("restoreInterval", 10*time.Minute, "how often failed account restores are retried")
+	restoreSecret := flag.String("restoreSecret", "", "the secret signing restore tokens, required unless running against the emulator")
+	smtpHost := flag.String("smtpHost", "", "the smtp server as host:port, required unless running against the emulator")
+	smtpFrom := flag.String("smtpFrom", "noreply@screensociety.de", "the sender of mails")
+	smtpUser := flag.String("smtpUser", "", "the smtp username, empty to send without authentication")
+	smtpPassword := flag.String("smtpPassword", "", "the smtp password")
+	removeDuplicates := flag.Bool("removeDuplicates", false, "remove duplicate movies from the cache once, so the unique imdbId index can be created")
 	flag.Parse()
+	// Restore tokens must survive restarts and must not end up in the logs
+	if !*emulator && (*restoreSecret == "" || *smtpHost == "") {
+		log.Fatal("The restoreSecret and smtpHost flags are required unless running against the emulator")
+	}
 	mongoHandler, err := MovieHandlers.NewMongoHandler(*mongoHost)
 	if err != nil {
 		log.Fatal("Failed to create MongoHandler:", err)
 	}
+	mongoHandler.StreamingTTL = *streamingTTL
+	if !MovieHandlers.ProvidesStreaming(*providerName) {
+		mongoHandler.StreamingTTL = 0
+	}
+	mongoHandler.MetadataTTL = *metadataTTL
+	mongoHandler.SearchTTL = *searchTTL
+	if *removeDuplicates {
+		go mongoHandler.RemoveDuplicates()
+	}
+	quotaTracker := MovieHandlers.NewQuotaTracker(mongoHandler)
+	quotaTracker.DailyBudget = *dailyBudget
+	quotaTracker.MonthlyBudget = *monthlyBudget
+	quotaTracker.Threshold = *budgetThreshold
+	upstreamClient := MovieHandlers.NewUpstreamClient(*providerName)
+	upstreamClient.Timeout = *upstreamTimeout
+	upstreamClient.MaxRetries = *upstreamRetries
+	movieProvider, err := MovieHandlers.NewMovieProvider(*providerName, *tmdbToken, *fixtureFile, upstreamClient, quotaTracker)
+	if err != nil {
+		log.Fatal("Failed to create MovieProvider:", err)
+	}
+	cacheRefresher := &MovieHandlers.CacheRefresher{
+		Mongo:       mongoHandler,
+		Provider:    movieProvider,
+		DailyBudget: *refreshBudget,
+		Interval:    *refreshInterval,
+	}
 	if *emulator {
 		err := os.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:9000")
 		if err != nil {
 			log.Fatal("Failed to set FIRESTORE_EMULATOR_HOST:", err)
 		}
+		err = os.Setenv("STORAGE_EMULATOR_HOST", "localhost:9199")
+		if err != nil {
+			log.Fatal("Failed to set STORAGE_EMULATOR_HOST:", err)
+		}
 	}
 	opt := option.WithCredentialsFile("main/serviceAccountKey.json")
-	app, err := firebase.NewApp(context.Background(), nil, opt)
+	var config *firebase.Config
+	if *storageBucket != "" {
+		config = &firebase.Config{StorageBucket: *storageBucket}
+	}
+	app, err := firebase.NewApp(context.Background(), config, opt)
 	if err != nil {
 		log.Fatalf("error initializing app: %v", err)
 	}
@@ -46,20 +111,73 @@ func main() {
 		log.Fatalf("error getting Messaging client: %v\n", err)
 	}
 
+	var profilePictures *FirebaseHandlers.ProfilePictures
+	if *storageBucket != "" {
+		storageHandler, err := app.Storage(context.Background())
+		if err != nil {
+			log.Fatalf("error getting Storage client: %v\n", err)
+		}
+		bucket, err := storageHandler.DefaultBucket()
+		if err != nil {
+			log.Fatalf("error getting Storage bucket: %v\n", err)
+		}
+		profilePictures = &FirebaseHandlers.ProfilePictures{Bucket: bucket}
+	}
+
+	profileHandler := &FirebaseHandlers.ProfileHandler{
+		AuthHandler: authHandler,
+		FireStore:   firestoreHandler,
+	}
 	searchHandler := &MovieHandlers.SearchHandler{
-		Mongo: mongoHandler,
+		Mongo:     mongoHandler,
+		Provider:  movieProvider,
+		Countries: profileHandler,
 	}
 	inspectHandler := &MovieHandlers.InspectHandler{
-		Mongo: mongoHandler,
+		AuthHandler: authHandler,
+		Mongo:       mongoHandler,
+		Provider:    movieProvider,
+		Countries:   profileHandler,
+	}
+	quotaHandler := &MovieHandlers.QuotaHandler{
+		AuthHandler: authHandler,
+		Quota:       quotaTracker,
+	}
+	statusHandler := &MovieHandlers.StatusHandler{
+		Upstream: upstreamClient,
+	}
+	priceHandler := &MovieHandlers.PriceHandler{
+		AuthHandler: authHandler,
+		Mongo:       mongoHandler,
 	}
+	restoreTokens := FirebaseHandlers.NewRestoreTokens(*restoreSecret)
+	mailer := FirebaseHandlers.NewMailer(*smtpHost, *smtpFrom, *smtpUser, *smtpPassword)
 	deletionHandler := &FirebaseHandlers.DeletionHandler{
 		AuthHandler: authHandler,
 		FireStore:   firestoreHandler,
+		Pictures:    profilePictures,
+		Tokens:      restoreTokens,
+		Mailer:      mailer,
+		Interval:    *deletionInterval,
 	}
+	go deletionHandler.Run()
 	restoreHandler := &FirebaseHandlers.RestoreHandler{
 		AuthHandler: authHandler,
 		FireStore:   firestoreHandler,
+		Pictures:    profilePictures,
+		Tokens:      restoreTokens,
+		Mailer:      mailer,
+		Interval:    *restoreInterval,
 	}
+	go restoreHandler.Run()
+	archiveJanitor := &FirebaseHandlers.ArchiveJanitor{
+		AuthHandler: authHandler,
+		FireStore:   firestoreHandler,
+		Pictures:    profilePictures,
+		Interval:    *purgeInterval,
+		DryRun:      *purgeDryRun,
+	}
+	go archiveJanitor.Run()
 	fcmHandler := &FirebaseHandlers.FcmHandler{
 		AuthHandler:  authHandler,
 		FireStore:    firestoreHandler,
@@ -71,6 +189,46 @@ func main() {
 		FireStore:   firestoreHandler,
 		FcmHandler:  fcmHandler,
 	}
+	watchlistHandler := &FirebaseHandlers.WatchlistHandler{
+		AuthHandler:  authHandler,
+		FireStore:    firestoreHandler,
+		MongoHandler: mongoHandler,
+	}
+	tasteHandler := &FirebaseHandlers.TasteHandler{
+		AuthHandler:   authHandler,
+		FireStore:     firestoreHandler,
+		MongoHandler:  mongoHandler,
+		FriendHandler: friendHandler,
+		FcmHandler:    fcmHandler,
+	}
+	feedHandler := &FirebaseHandlers.FeedHandler{
+		AuthHandler:  authHandler,
+		FireStore:    firestoreHandler,
+		MongoHandler: mongoHandler,
+	}
+	commentHandler := &FirebaseHandlers.CommentHandler{
+		AuthHandler: authHandler,
+		FireStore:   firestoreHandler,
+		FcmHandler:  fcmHandler,
+	}
+	pollHandler := &FirebaseHandlers.PollHandler{
+		AuthHandler:  authHandler,
+		FireStore:    firestoreHandler,
+		MongoHandler: mongoHandler,
+		FcmHandler:   fcmHandler,
+		Interval:     *pollInterval,
+	}
+	go pollHandler.Run()
+	availabilityHandler := &FirebaseHandlers.AvailabilityHandler{
+		AuthHandler:  authHandler,
+		FireStore:    firestoreHandler,
+		MongoHandler: mongoHandler,
+		FcmHandler:   fcmHandler,
+		Interval:     *availabilityInterval,
+	}
+	go availabilityHandler.Run()
+	cacheRefresher.Priority = availabilityHandler.WatchedTitles
+	go cacheRefresher.Run()
 
 	// Create a new router
 	mux := http.NewServeMux()
@@ -78,8 +236,16 @@ func main() {
 	// Register request handlers
 	mux.Handle("/search", searchHandler)
 	mux.Handle("/inspect", inspectHandler)
+	mux.HandleFunc("/inspect/batch", inspectHandler.BatchWrapper)
+	mux.Handle("/prices", priceHandler)
+	mux.Handle("/status", statusHandler)
+	mux.Handle("/admin/quota", quotaHandler)
+	mux.Handle("/admin/purge", archiveJanitor)
 	mux.Handle("/delete", deletionHandler)
+	mux.HandleFunc("/delete/status", deletionHandler.StatusWrapper)
 	mux.Handle("/restore", restoreHandler)
+	mux.HandleFunc("/restore/status", restoreHandler.StatusWrapper)
+	mux.HandleFunc("/restore/resend", restoreHandler.ResendWrapper)
 	mux.HandleFunc("/revoke", friendHandler.RevokeRequestWrapper)
 	mux.HandleFunc("/accept", friendHandler.AcceptRequestWrapper)
 	mux.HandleFunc("/send", friendHandler.SendRequestWrapper)
@@ -87,6 +253,28 @@ func main() {
 	mux.HandleFunc("/decline", friendHandler.DeclineRequestWrapper)
 	mux.HandleFunc("/addedToken", fcmHandler.AddedTokenWrapper)
 	mux.HandleFunc("/ratedMovie", fcmHandler.RatedMovieWrapper)
+	mux.HandleFunc("/country", profileHandler.SetCountryWrapper)
+	mux.HandleFunc("/watchlist", watchlistHandler.ListWrapper)
+	mux.HandleFunc("/watchlist/add", watchlistHandler.AddWrapper)
+	mux.HandleFunc("/watchlist/remove", watchlistHandler.RemoveWrapper)
+	mux.HandleFunc("/services", profileHandler.SetServicesWrapper)
+	mux.HandleFunc("/follow", availabilityHandler.FollowWrapper)
+	mux.HandleFunc("/unfollow", availabilityHandler.UnfollowWrapper)
+	mux.HandleFunc("/priceAlert", availabilityHandler.SetPriceAlertWrapper)
+	mux.HandleFunc("/priceAlert/remove", availabilityHandler.RemovePriceAlertWrapper)
+	mux.HandleFunc("/recommendations", tasteHandler.RecommendationsWrapper)
+	mux.Handle("/feed", feedHandler)
+	mux.HandleFunc("/compatibility", tasteHandler.CompatibilityWrapper)
+	mux.HandleFunc("/movieNight", tasteHandler.MovieNightWrapper)
+	mux.HandleFunc("/comments", commentHandler.ListWrapper)
+	mux.HandleFunc("/comments/reply", commentHandler.ReplyWrapper)
+	mux.HandleFunc("/comments/reply/edit", commentHandler.EditReplyWrapper)
+	mux.HandleFunc("/comments/reply/delete", commentHandler.DeleteReplyWrapper)
+	mux.HandleFunc("/comments/react", commentHandler.ReactWrapper)
+	mux.HandleFunc("/comments/react/remove", commentHandler.RemoveReactionWrapper)
+	mux.HandleFunc("/poll", pollHandler.GetWrapper)
+	mux.HandleFunc("/poll/create", pollHandler.CreateWrapper)
+	mux.HandleFunc("/poll/vote", pollHandler.VoteWrapper)
 	http.Handle("/", mux)
 
 	log.Println("Server listening on http://localhost:8080/")